@@ -1,6 +1,8 @@
 package gossie
 
 import (
+	"context"
+
 	"github.com/wadey/gossie/src/cassandra"
 )
 
@@ -33,6 +35,9 @@ type Batch interface {
 	GetWriter() Writer
 	// Run this batch
 	Run() error
+
+	// RunContext runs this batch, giving up when ctx is done and returning ctx.Err()
+	RunContext(ctx context.Context) error
 }
 
 type batch struct {
@@ -105,8 +110,12 @@ func (b *batch) GetWriter() Writer {
 }
 
 func (b *batch) Run() error {
+	return b.RunContext(context.Background())
+}
+
+func (b *batch) RunContext(ctx context.Context) error {
 	if b.mappingError != nil {
 		return b.mappingError
 	}
-	return b.writer.Run()
+	return b.writer.RunContext(ctx)
 }
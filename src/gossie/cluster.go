@@ -0,0 +1,302 @@
+package gossie
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+const defaultPort = "9160"
+
+// cluster holds the nodes of a pool, their token ring and their datacenters. It is shared by
+// all the pools derived with WithTracer. The nodes slice is never modified in place, a new one
+// is set instead, so a snapshot can be used without holding the lock.
+type cluster struct {
+	stats       counters // first to be 64-bit aligned for atomic access
+	nodes       []*node
+	ring        *tokenRing
+	datacenters map[*node]string
+	seeds       []string  // "host:port" the nodes were resolved from, resolved again by the resolver
+	latencies   latencies // of the successful speculative transactions
+	generation  int32     // incremented to recycle all the connections
+	remoteNext  uint32    // rotates the nodes of the remote datacenters picked by byDatacenter
+	m           sync.RWMutex
+
+	// lifecycle of the pool
+	running int           // transactions in progress
+	closed  bool          // no more transactions are accepted
+	drained chan struct{} // closed when the pool is closed and no transaction is running
+	done    chan struct{} // closed to stop the background goroutines
+	lm      sync.Mutex
+}
+
+func newCluster(addrs []string, maxConnections int) *cluster {
+	c := &cluster{
+		nodes:   make([]*node, len(addrs)),
+		drained: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for i, addr := range addrs {
+		c.nodes[i] = newNode(addr, maxConnections)
+	}
+	return c
+}
+
+// get returns a snapshot of the nodes and token ring
+func (c *cluster) get() ([]*node, *tokenRing) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.nodes, c.ring
+}
+
+// knownDatacenters returns the datacenters of the nodes found in the last token ring
+func (c *cluster) knownDatacenters() map[*node]string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.datacenters
+}
+
+// update sets the token ring and the datacenters of the nodes found in hosts, after adding the
+// added nodes and removing the removed ones from the current nodes. The nodes that were added or
+// removed since hosts was built are kept as they are: an added node whose address is already
+// present is replaced by the present one, and only the removed nodes that are still present are
+// removed and returned. Nodes that are not present anymore are left out of the ring. The ring is
+// nil if p is nil.
+func (c *cluster) update(p partitioner, ranges []*cassandra.TokenRange, hosts map[string]*node, added, removed []*node) ([]*node, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	gone := make(map[*node]bool, len(removed))
+	for _, n := range removed {
+		gone[n] = true
+	}
+	var nodes, left []*node
+	byAddr := make(map[string]*node, len(c.nodes)+len(added))
+	for _, n := range c.nodes {
+		if gone[n] {
+			left = append(left, n)
+			continue
+		}
+		nodes = append(nodes, n)
+		byAddr[n.node] = n
+	}
+	for _, n := range added {
+		if _, ok := byAddr[n.node]; !ok {
+			nodes = append(nodes, n)
+			byAddr[n.node] = n
+		}
+	}
+
+	present := make(map[string]*node, len(hosts))
+	for host, n := range hosts {
+		if n, ok := byAddr[n.node]; ok {
+			present[host] = n
+		}
+	}
+	var ring *tokenRing
+	var err error
+	if p != nil {
+		ring, err = newTokenRing(p, ranges, present)
+	}
+	c.nodes, c.ring, c.datacenters = nodes, ring, nodeDatacenters(ranges, present)
+	return left, err
+}
+
+// byDatacenter splits the nodes in the ones that belong to the local datacenter and up to
+// perRemote nodes of every other datacenter, rotated on every call so the failover load is
+// spread over all the remote nodes. Nodes with an unknown datacenter are considered local. If
+// local is empty all the nodes are returned as local.
+func (c *cluster) byDatacenter(local string, perRemote int) (locals, remotes []*node) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	if local == "" {
+		return c.nodes, nil
+	}
+	var dcs []string
+	byDC := make(map[string][]*node)
+	for _, n := range c.nodes {
+		dc, ok := c.datacenters[n]
+		if !ok || dc == local {
+			locals = append(locals, n)
+			continue
+		}
+		if byDC[dc] == nil {
+			dcs = append(dcs, dc)
+		}
+		byDC[dc] = append(byDC[dc], n)
+	}
+	if perRemote <= 0 {
+		return locals, nil
+	}
+	next := int(atomic.AddUint32(&c.remoteNext, 1))
+	for _, dc := range dcs {
+		nodes := byDC[dc]
+		for i := 0; i < perRemote && i < len(nodes); i++ {
+			remotes = append(remotes, nodes[(next+i)%len(nodes)])
+		}
+	}
+	return locals, remotes
+}
+
+// local filters nodes keeping only the ones in the local datacenter
+func (c *cluster) local(nodes []*node, local string) []*node {
+	if local == "" {
+		return nodes
+	}
+	c.m.RLock()
+	defer c.m.RUnlock()
+	var locals []*node
+	for _, n := range nodes {
+		if dc, ok := c.datacenters[n]; !ok || dc == local {
+			locals = append(locals, n)
+		}
+	}
+	return locals
+}
+
+// nodeDatacenters maps the pool nodes to the datacenter reported for them in the ring
+func nodeDatacenters(ranges []*cassandra.TokenRange, hosts map[string]*node) map[*node]string {
+	datacenters := make(map[*node]string)
+	for _, tr := range ranges {
+		endpoints := rangeEndpoints(tr)
+		if len(tr.EndpointDetails) != len(endpoints) {
+			continue
+		}
+		for i, e := range endpoints {
+			if n, ok := hosts[e]; ok && tr.EndpointDetails[i] != nil {
+				datacenters[n] = tr.EndpointDetails[i].Datacenter
+			}
+		}
+	}
+	return datacenters
+}
+
+// discoverNodes returns the nodes that are present in the token ring, reusing the current
+// ones when possible, and the current nodes that are not part of the ring anymore. New
+// nodes are assumed to listen on the same port as the first current node. If the ring
+// has no endpoints at all the current nodes are kept as they are.
+//
+// The ring of a keyspace only lists the datacenters it is replicated to, so a current node
+// missing from it is only removed if known, the datacenters found in the previous ring, places
+// it in one of those datacenters, or if the ring has no datacenter details at all.
+func discoverNodes(current []*node, known map[*node]string, ranges []*cassandra.TokenRange, maxConnections int) (nodes, removed []*node) {
+	port := defaultPort
+	if len(current) > 0 {
+		if _, p, err := net.SplitHostPort(current[0].node); err == nil {
+			port = p
+		}
+	}
+
+	hosts := hostIndex(current)
+	seen := make(map[*node]bool)
+	datacenters := make(map[string]bool)
+	var added []*node
+	for _, tr := range ranges {
+		for _, d := range tr.EndpointDetails {
+			if d != nil {
+				datacenters[d.Datacenter] = true
+			}
+		}
+		for _, e := range rangeEndpoints(tr) {
+			n, ok := hosts[e]
+			if !ok {
+				n = newNode(net.JoinHostPort(e, port), maxConnections)
+				hosts[e] = n
+				added = append(added, n)
+			}
+			seen[n] = true
+		}
+	}
+	if len(seen) == 0 {
+		return current, nil
+	}
+
+	for _, n := range current {
+		dc, ok := known[n]
+		if seen[n] || (len(datacenters) > 0 && !(ok && datacenters[dc])) {
+			nodes = append(nodes, n)
+		} else {
+			removed = append(removed, n)
+		}
+	}
+	nodes = append(nodes, added...)
+	return nodes, removed
+}
+
+// begin registers a new running transaction, or returns ErrPoolClosed
+func (c *cluster) begin() error {
+	c.lm.Lock()
+	defer c.lm.Unlock()
+	if c.closed {
+		return ErrPoolClosed
+	}
+	c.running++
+	return nil
+}
+
+// end unregisters a running transaction
+func (c *cluster) end() {
+	c.lm.Lock()
+	defer c.lm.Unlock()
+	c.running--
+	if c.closed && c.running == 0 {
+		close(c.drained)
+	}
+}
+
+// drain stops accepting transactions and the background goroutines, then waits up to timeout for
+// the running transactions to finish. It returns false if they did not finish in time.
+func (c *cluster) drain(timeout time.Duration) bool {
+	c.lm.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+		if c.running == 0 {
+			close(c.drained)
+		}
+	}
+	c.lm.Unlock()
+
+	select {
+	case <-c.drained:
+		return true
+	default:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.drained:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// refresher periodically reloads the token ring and, if enabled, the node list
+func (cp *connectionPool) refresher(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-ticker.C:
+		}
+		if err := cp.refreshRing(); err != nil {
+			cp.options.Logger.Warn("Cannot refresh the token ring", "error", err)
+		}
+	}
+}
+
+// remove marks a node as no longer part of the pool and closes its idle connections.
+// Connections that are in use are closed when they are released.
+func (n *node) remove() {
+	atomic.StoreInt32(&n.removed, 1)
+	n.closeIdle()
+}
+
+func (n *node) isRemoved() bool {
+	return atomic.LoadInt32(&n.removed) != 0
+}
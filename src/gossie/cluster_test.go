@@ -0,0 +1,185 @@
+package gossie
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func TestDiscoverNodes(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9161"}
+	n2 := &node{node: "10.0.0.2:9161"}
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "0", EndToken: "10", Endpoints: []string{"10.0.0.1", "10.0.0.3"}},
+		&cassandra.TokenRange{StartToken: "10", EndToken: "0", Endpoints: []string{"10.0.0.3", "10.0.0.1"}},
+	}
+
+	nodes, removed := discoverNodes([]*node{n1, n2}, nil, ranges, 0)
+	assert.Equal(t, []*node{n2}, removed)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, n1, nodes[0])
+	assert.Equal(t, "10.0.0.3:9161", nodes[1].node)
+
+	nodes, removed = discoverNodes([]*node{n1, n2}, nil, []*cassandra.TokenRange{}, 0)
+	assert.Equal(t, []*node{n1, n2}, nodes)
+	assert.Empty(t, removed)
+}
+
+func TestDiscoverNodesDatacenters(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9160"}
+	n2 := &node{node: "10.0.0.2:9160"}
+	n3 := &node{node: "10.1.0.1:9160"}
+	details := []*cassandra.EndpointDetails{&cassandra.EndpointDetails{Host: "10.0.0.1", Datacenter: "dc1"}}
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "0", EndToken: "0", Endpoints: []string{"10.0.0.1"}, EndpointDetails: details},
+	}
+
+	// the keyspace is not replicated to the datacenter of n3, which is kept, while n2 left dc1
+	known := map[*node]string{n1: "dc1", n2: "dc1"}
+	nodes, removed := discoverNodes([]*node{n1, n2, n3}, known, ranges, 0)
+	assert.Equal(t, []*node{n1, n3}, nodes)
+	assert.Equal(t, []*node{n2}, removed)
+
+	known[n3] = "dc2"
+	nodes, removed = discoverNodes([]*node{n1, n3}, known, ranges, 0)
+	assert.Equal(t, []*node{n1, n3}, nodes)
+	assert.Empty(t, removed)
+}
+
+func TestEmptyCluster(t *testing.T) {
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{}}, options: DefaultPoolOptions}
+	_, err := cp.acquire()
+	assert.Error(t, err)
+}
+
+func TestDatacenters(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9160"}
+	n2 := &node{node: "10.0.0.2:9160"}
+	n3 := &node{node: "10.1.0.1:9160"}
+	n4 := &node{node: "10.1.0.2:9160"}
+	n5 := &node{node: "10.2.0.1:9160"}
+	nodes := []*node{n1, n2, n3, n4, n5}
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "0", EndToken: "10",
+			Endpoints: []string{"10.0.0.1", "10.1.0.1", "10.1.0.2"},
+			EndpointDetails: []*cassandra.EndpointDetails{
+				&cassandra.EndpointDetails{Host: "10.0.0.1", Datacenter: "DC1"},
+				&cassandra.EndpointDetails{Host: "10.1.0.1", Datacenter: "DC2"},
+				&cassandra.EndpointDetails{Host: "10.1.0.2", Datacenter: "DC2"},
+			},
+		},
+	}
+	c := &cluster{nodes: nodes, datacenters: nodeDatacenters(ranges, hostIndex(nodes))}
+
+	locals, remotes := c.byDatacenter("", 1)
+	assert.Equal(t, nodes, locals)
+	assert.Empty(t, remotes)
+
+	// n2 and n5 have no datacenter information so they are considered local
+	locals, remotes = c.byDatacenter("DC1", 1)
+	assert.Equal(t, []*node{n1, n2, n5}, locals)
+	assert.Equal(t, 1, len(remotes))
+	// the remote nodes are rotated
+	_, next := c.byDatacenter("DC1", 1)
+	assert.ElementsMatch(t, []*node{n3, n4}, append(remotes, next...))
+	_, remotes = c.byDatacenter("DC1", 2)
+	assert.ElementsMatch(t, []*node{n3, n4}, remotes)
+
+	locals, remotes = c.byDatacenter("DC2", 0)
+	assert.Equal(t, []*node{n2, n3, n4, n5}, locals)
+	assert.Empty(t, remotes)
+
+	assert.Equal(t, []*node{n1}, c.local([]*node{n1, n3, n4}, "DC1"))
+
+	options := DefaultPoolOptions
+	options.LocalDatacenter, options.RemoteNodes = "DC1", 1
+	cp := &connectionPool{cluster: c, options: options}
+	now := int(nowfunc().Unix())
+	plan := cp.newQueryPlan(nil, now)
+	assert.Equal(t, 4, len(plan.nodes))
+	remote := plan.nodes[3]
+	assert.Contains(t, []*node{n3, n4}, remote)
+
+	n1.blacklist()
+	n2.blacklist()
+	n5.blacklist()
+	assert.Equal(t, remote, plan.nextNode(cp, now))
+	assert.Equal(t, 1, len(cp.newQueryPlan(nil, now).nodes))
+
+	n3.blacklist()
+	n4.blacklist()
+	assert.Nil(t, plan.nextNode(cp, now))
+	assert.Empty(t, cp.newQueryPlan(nil, now).nodes)
+}
+
+func TestClusterDrain(t *testing.T) {
+	c := newCluster([]string{localEndpoint}, 0)
+	assert.NoError(t, c.begin())
+	assert.False(t, c.drain(time.Millisecond))
+	assert.Equal(t, ErrPoolClosed, c.begin())
+
+	go func() {
+		time.Sleep(time.Millisecond)
+		c.end()
+	}()
+	assert.True(t, c.drain(time.Second))
+	assert.True(t, c.drain(0))
+
+	cp := &connectionPool{cluster: c, options: DefaultPoolOptions}
+	assert.Equal(t, ErrPoolClosed, cp.run(func(*connection) error { return nil }))
+	cp.Close()
+	assert.True(t, c.nodes[0].isRemoved())
+}
+
+func TestRefreshRingConcurrentChanges(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "0", EndToken: "0", Endpoints: []string{"127.0.0.1", "10.0.0.3", "10.0.0.5"}},
+	}
+	cli.EXPECT().DescribePartitioner().Return("org.apache.cassandra.dht.Murmur3Partitioner", nil)
+	cli.EXPECT().DescribeRing("ks").Return(ranges, nil)
+
+	cp := testScanPool(cli, 1)
+	cp.options.DiscoverNodes = true
+	n1 := cp.cluster.nodes[0]
+	n2 := newNode("10.0.0.2:9160", 0)
+	n5 := newNode("10.0.0.5:9160", 0)
+	for _, n := range []*node{n2, n5} {
+		n.state, n.markedDown = NodeDown, true
+	}
+	cp.cluster.nodes = append(cp.cluster.nodes, n2, n5)
+
+	// a node is added and another one removed while the hosts of the ring are resolved
+	var once sync.Once
+	lookupHost = func(host string) ([]string, error) {
+		once.Do(func() {
+			cp.AddNode("10.0.0.4:9160")
+			assert.NoError(t, cp.RemoveNode("10.0.0.5:9160"))
+		})
+		if host == "localhost" {
+			return []string{"127.0.0.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func() { lookupHost = net.LookupHost }()
+
+	assert.NoError(t, cp.refreshRing())
+	nodes, ring := cp.cluster.get()
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.node)
+	}
+	assert.Equal(t, []string{localEndpoint, "10.0.0.4:9160", "10.0.0.3:9160"}, addrs)
+	assert.Equal(t, n1, nodes[0])
+	assert.True(t, n2.isRemoved())
+	assert.Equal(t, []*node{n1, nodes[2]}, ring.replicasFor([]byte("key")))
+}
@@ -0,0 +1,150 @@
+package gossie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	. "github.com/wadey/gossie/src/cassandra"
+)
+
+// ErrSuperColumnIter is returned by ColumnIterator.Next when it reads a page of super columns,
+// the subcolumns of a super column are iterated setting Reader.SuperColumn
+var ErrSuperColumnIter = errors.New("Cannot iterate over super columns, set Reader.SuperColumn")
+
+// ErrColumnPageNotAdvanced is returned by ColumnIterator.Next when a full page of columns ends
+// where the previous one did, so reading the next page would return it again
+var ErrColumnPageNotAdvanced = errors.New("Column page did not advance")
+
+// ColumnIterator pages over the columns of one or more rows, see Reader.ColumnIter
+type ColumnIterator interface {
+
+	// Next returns the next column, reading a new page of columns when the current one is
+	// exhausted. It returns nil and Done when there are no more columns. Calling Next again after
+	// any other error retries reading the page that failed.
+	Next() (*Column, error)
+
+	// Key returns the row key of the last column returned by Next
+	Key() []byte
+}
+
+type columnIterator struct {
+	reader  *reader
+	ctx     context.Context
+	keys    [][]byte  // keys left to read, the current one first
+	slice   Slice     // Count is the page size
+	start   []byte    // first column of the next page
+	skip    bool      // start was already returned, the next page begins after it
+	done    bool      // there are no more columns in the current key
+	columns []*Column // columns of the current page not returned yet
+	key     []byte
+	err     error // error returned by every call of Next
+}
+
+func (r *reader) ColumnIter(key []byte, slice *Slice) ColumnIterator {
+	return r.MultiColumnIterContext(context.Background(), [][]byte{key}, slice)
+}
+
+func (r *reader) ColumnIterContext(ctx context.Context, key []byte, slice *Slice) ColumnIterator {
+	return r.MultiColumnIterContext(ctx, [][]byte{key}, slice)
+}
+
+func (r *reader) MultiColumnIter(keys [][]byte, slice *Slice) ColumnIterator {
+	return r.MultiColumnIterContext(context.Background(), keys, slice)
+}
+
+func (r *reader) MultiColumnIterContext(ctx context.Context, keys [][]byte, slice *Slice) ColumnIterator {
+	it := &columnIterator{reader: r, ctx: ctx, keys: keys}
+	if slice != nil {
+		it.slice = *slice
+	}
+	if it.slice.Count <= 0 {
+		it.slice.Count = defaultRange.Count
+	}
+	it.start = it.slice.Start
+	if r.columnParent.ColumnFamily == "" {
+		it.err = errors.New("No column family specified")
+	}
+	return it
+}
+
+func (it *columnIterator) Next() (*Column, error) {
+	for len(it.columns) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if len(it.keys) == 0 {
+			return nil, Done
+		}
+		if it.done {
+			it.keys = it.keys[1:]
+			it.start, it.skip, it.done = it.slice.Start, false, false
+			continue
+		}
+		if err := it.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	c := it.columns[0]
+	it.columns = it.columns[1:]
+	it.key = it.keys[0]
+	return c, nil
+}
+
+func (it *columnIterator) Key() []byte {
+	return it.key
+}
+
+// fetch reads the next page of columns of the current key
+func (it *columnIterator) fetch() (err error) {
+	r := it.reader
+	key := it.keys[0]
+	ctx, end := r.startSpan(it.ctx, "ColumnIterator.Next", 1)
+	defer func() { end(err) }()
+
+	page := it.slice
+	page.Start = it.start
+	if it.skip {
+		page.Count++
+	}
+	sp := NewSlicePredicate()
+	sp.SliceRange = sliceToCassandra(&page)
+
+	var ret []*ColumnOrSuperColumn
+	err = r.pool.runOperation(ctx, r.speculativeOperation(key), func(c *connection) error {
+		res, err := c.client.GetSlice(key, &r.columnParent, sp, c.consistency)
+		if err == nil {
+			c.commit(func() { ret = res })
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	it.done = len(ret) < page.Count
+	row := rowFromTListColumns(key, ret)
+	if row == nil {
+		return nil
+	}
+	if len(row.SuperColumns) > 0 {
+		it.err = ErrSuperColumnIter
+		return it.err
+	}
+	columns := row.Columns
+	if it.skip && len(columns) > 0 && bytes.Equal(columns[0].Name, it.start) {
+		columns = columns[1:]
+	}
+	stuck := len(columns) == 0 || (it.skip && bytes.Equal(columns[len(columns)-1].Name, it.start))
+	if !it.done && stuck {
+		// a full page without columns after start, reading from start again returns it again
+		it.err = ErrColumnPageNotAdvanced
+		return it.err
+	}
+	if len(columns) > 0 {
+		it.start = columns[len(columns)-1].Name
+		it.skip = true
+	}
+	it.columns = columns
+	return nil
+}
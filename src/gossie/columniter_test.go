@@ -0,0 +1,134 @@
+package gossie
+
+import (
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func testColumns(names ...string) []*ColumnOrSuperColumn {
+	columns := make([]*ColumnOrSuperColumn, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, &ColumnOrSuperColumn{Column: &Column{Name: []byte(name), Value: []byte(name)}})
+	}
+	return columns
+}
+
+func testSlicePredicate(start, end string, count int, reversed bool) *SlicePredicate {
+	return &SlicePredicate{SliceRange: sliceToCassandra(&Slice{Start: []byte(start), End: []byte(end), Count: count, Reversed: reversed})}
+}
+
+type keyColumn struct {
+	key, name string
+}
+
+func iterColumns(it ColumnIterator) ([]keyColumn, error) {
+	var columns []keyColumn
+	for {
+		c, err := it.Next()
+		if err != nil {
+			return columns, err
+		}
+		columns = append(columns, keyColumn{string(it.Key()), string(c.Name)})
+	}
+}
+
+func TestColumnIterReversed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	key := []byte("key")
+	gomock.InOrder(
+		cli.EXPECT().GetSlice(key, cp, testSlicePredicate("z", "b", 2, true), ConsistencyLevel_ONE).Return(testColumns("y", "x"), nil),
+		cli.EXPECT().GetSlice(key, cp, testSlicePredicate("x", "b", 3, true), ConsistencyLevel_ONE).Return(testColumns("x", "w", "v"), nil),
+		cli.EXPECT().GetSlice(key, cp, testSlicePredicate("v", "b", 3, true), ConsistencyLevel_ONE).Return(testColumns("v", "b"), nil),
+	)
+
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").
+		ColumnIter(key, &Slice{Start: []byte("z"), End: []byte("b"), Count: 2, Reversed: true})
+	columns, err := iterColumns(it)
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []keyColumn{{"key", "y"}, {"key", "x"}, {"key", "w"}, {"key", "v"}, {"key", "b"}}, columns)
+}
+
+func TestMultiColumnIter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	gomock.InOrder(
+		cli.EXPECT().GetSlice([]byte("k1"), cp, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(testColumns("a"), nil),
+		cli.EXPECT().GetSlice([]byte("k2"), cp, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(nil, nil),
+		cli.EXPECT().GetSlice([]byte("k3"), cp, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(testColumns("a", "b"), nil),
+		cli.EXPECT().GetSlice([]byte("k3"), cp, testSlicePredicate("b", "", 3, false), ConsistencyLevel_ONE).Return(testColumns("b"), nil),
+	)
+
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").
+		MultiColumnIter([][]byte{[]byte("k1"), []byte("k2"), []byte("k3")}, &Slice{Count: 2})
+	columns, err := iterColumns(it)
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []keyColumn{{"k1", "a"}, {"k3", "a"}, {"k3", "b"}}, columns)
+}
+
+func TestColumnIterNotAdvancing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	key := []byte("key")
+	gomock.InOrder(
+		cli.EXPECT().GetSlice(key, cp, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(testColumns("a", "b"), nil),
+		cli.EXPECT().GetSlice(key, cp, testSlicePredicate("b", "", 3, false), ConsistencyLevel_ONE).Return(testColumns("b", "b", "b"), nil),
+	)
+
+	// the second page ends at b again, the iterator fails instead of reading it forever
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").ColumnIter(key, &Slice{Count: 2})
+	columns, err := iterColumns(it)
+	assert.Equal(t, ErrColumnPageNotAdvanced, err)
+	assert.Equal(t, []keyColumn{{"key", "a"}, {"key", "b"}}, columns)
+	_, err = it.Next()
+	assert.Equal(t, ErrColumnPageNotAdvanced, err)
+}
+
+func TestColumnIterNoColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	key := []byte("key")
+	// a full page of a standard column family whose entries hold no column
+	page := []*ColumnOrSuperColumn{&ColumnOrSuperColumn{}, &ColumnOrSuperColumn{}}
+	cli.EXPECT().GetSlice(key, cp, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(page, nil)
+
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").ColumnIter(key, &Slice{Count: 2})
+	_, err := it.Next()
+	assert.Equal(t, ErrColumnPageNotAdvanced, err)
+	_, err = it.Next()
+	assert.Equal(t, ErrColumnPageNotAdvanced, err)
+}
+
+func TestColumnIterSuperColumns(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	key := []byte("key")
+	page := []*ColumnOrSuperColumn{
+		&ColumnOrSuperColumn{SuperColumn: &SuperColumn{Name: []byte("a"), Columns: []*Column{&Column{Name: []byte("x")}}}},
+	}
+	gomock.InOrder(
+		cli.EXPECT().GetSlice(key, &ColumnParent{ColumnFamily: "cf"}, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(page, nil),
+		cli.EXPECT().GetSlice(key, &ColumnParent{ColumnFamily: "cf", SuperColumn: []byte("a")}, testSlicePredicate("", "", 2, false), ConsistencyLevel_ONE).Return(testColumns("x"), nil),
+	)
+
+	r := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf")
+	_, err := r.ColumnIter(key, &Slice{Count: 2}).Next()
+	assert.Equal(t, ErrSuperColumnIter, err)
+
+	columns, err := iterColumns(r.SuperColumn([]byte("a")).ColumnIter(key, &Slice{Count: 2}))
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []keyColumn{{"key", "x"}}, columns)
+}
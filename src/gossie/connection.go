@@ -2,27 +2,24 @@
 package gossie
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"math/rand"
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
-	"github.com/golang/glog"
 	"github.com/wadey/gossie/src/cassandra"
 )
 
 /*
    to do:
    auth
-   timeout while waiting for an available connection slot
-   panic handling inside run()?
    maybe more pooling options
-   Close()
 */
 
 // ConnectionPool implements a pool of Cassandra connections to one or more nodes
@@ -45,11 +42,55 @@ type ConnectionPool interface {
 	// Batch returns a high level interface for write operations over structs
 	Batch() Batch
 
-	// Close all the connections in the pool
+	// ParallelScan returns a scanner that splits the token ring with describe_ring and
+	// describe_splits_ex and passes the rows of the column family to handler from up to workers
+	// goroutines, one split each at a time
+	ParallelScan(cf string, workers int, handler ScanHandler) ParallelScanner
+
+	// Close all the connections in the pool, waiting up to PoolOptions.CloseTimeout for the
+	// ones in use to be released. The pools derived with WithTracer share the connections, so
+	// they are closed too. Operations started after Close return ErrPoolClosed.
 	Close()
 
 	// Attach a function that can wrap the internal cassandra clients for tracing
 	WithTracer(tracer Tracer) ConnectionPool
+
+	// WithLimits returns a pool that shares the connections of this one but throttles its
+	// operations with its own limits instead of PoolOptions.Limits, for example to keep
+	// background jobs from slowing down the rest of the traffic
+	WithLimits(limits Limits) ConnectionPool
+
+	// Stats returns a snapshot of the connections and counters of the pool. The pools derived
+	// with WithTracer share them.
+	Stats() PoolStats
+
+	// AddNode adds a "host:port" node to the pool, if it is not already part of it. It is used
+	// as a replica of the keys it owns once the token ring is refreshed. A host name is expanded
+	// to one node per address and resolved again with PoolOptions.ResolveInterval, like the
+	// nodes passed to NewConnectionPool.
+	AddNode(addr string)
+
+	// RemoveNode removes a node from the pool. No new transactions are sent to it, its idle
+	// connections are closed and the ones in use are closed once released. It waits up to
+	// PoolOptions.CloseTimeout for them, returning ErrNodeDraining if some are still in use.
+	// With PoolOptions.DiscoverNodes a node that is still in the token ring is added again
+	// when the ring is refreshed.
+	RemoveNode(addr string) error
+
+	// Nodes returns the state of the nodes of the pool, sorted by address
+	Nodes() []NodeInfo
+
+	// MarkDown takes a node down until MarkUp is called, its idle connections are closed and no
+	// new transactions are sent to it
+	MarkDown(addr string) error
+
+	// MarkUp brings back a node, whether it was taken down with MarkDown or by its circuit
+	// breaker
+	MarkUp(addr string) error
+
+	// QueryTrace reads the session and events of a request traced by Cassandra, see
+	// Reader.Trace and Writer.Trace
+	QueryTrace(session UUID) (*TraceSession, error)
 }
 
 type Tracer func(ConnectionPool, cassandra.Cassandra) cassandra.Cassandra
@@ -58,29 +99,70 @@ type Tracer func(ConnectionPool, cassandra.Cassandra) cassandra.Cassandra
 type connectionRunner interface {
 	run(t transaction) error
 	runWithRetries(t transaction, retries int) error
+	runOperation(ctx context.Context, op *operation, t transaction) error
+	startSpan(ctx context.Context, info OperationInfo) (context.Context, func(error))
+	splitByReplica(keys [][]byte) [][][]byte
 }
 
 // PoolOptions stores the options for the creation of a ConnectionPool
 type PoolOptions struct {
-	Size             int                        // keep up to Size connections PER NODE open and ready
-	ReadConsistency  cassandra.ConsistencyLevel // default read consistency
-	WriteConsistency cassandra.ConsistencyLevel // default write consistency
-	Timeout          time.Duration              // socket timeout
-	BleederInterval  time.Duration              // <del>kill a kitten</del> Close a least used connection every BleederInterval.
-	Grace            int                        // if a node is blacklisted try to contact it again after Grace seconds
-	Retries          int                        // retry queries for Retries times before raising an error
-	Authentication   map[string]string          // if one or more keys are present, login() is called with the values from Authentication
-	TLSConfig        *tls.Config                // present if using SSL, otherwise nil
+	Size                int                        // keep up to Size connections PER NODE open and ready
+	ReadConsistency     cassandra.ConsistencyLevel // default read consistency
+	WriteConsistency    cassandra.ConsistencyLevel // default write consistency
+	Timeout             time.Duration              // socket timeout
+	BleederInterval     time.Duration              // <del>kill a kitten</del> Close a least used connection every BleederInterval.
+	Grace               int                        // if a node is blacklisted try to contact it again after Grace seconds
+	Retries             int                        // retry queries for Retries times before raising an error
+	RetryPolicy         RetryPolicy                // decides which failed queries are retried and when, retry right away by default
+	Authentication      map[string]string          // if one or more keys are present, login() is called with the values from Authentication
+	TLSConfig           *tls.Config                // present if using SSL, otherwise nil
+	DiscoverNodes       bool                       // find the rest of the cluster from the token ring of the passed nodes and keep the node list up to date
+	RefreshInterval     time.Duration              // reload the token ring (and the discovered nodes) every RefreshInterval
+	LocalDatacenter     string                     // if set only nodes in this datacenter are used while any of them is not blacklisted
+	RemoteNodes         int                        // when all the local nodes are blacklisted use up to RemoteNodes nodes of each remote datacenter
+	LoadBalancingPolicy LoadBalancingPolicy        // decides the order in which nodes are tried, random by default
+	MaxConnections      int                        // if not 0, never open more than MaxConnections connections PER NODE
+	AcquireTimeout      time.Duration              // wait up to AcquireTimeout in total for a connection when the nodes reached MaxConnections
+	CloseTimeout        time.Duration              // Close waits up to CloseTimeout for the connections in use to be released
+	HealthCheckInterval time.Duration              // if not 0, probe the nodes that are down every HealthCheckInterval and use them again only once they answer, instead of after Grace
+	ErrorRateThreshold  float64                    // if not 0, take down a node when at least this fraction of its transactions fail
+	LatencyThreshold    time.Duration              // if not 0, transactions slower than LatencyThreshold count as failed for ErrorRateThreshold
+	BreakerWindow       time.Duration              // ErrorRateThreshold is checked over periods of BreakerWindow
+	BreakerMinRequests  int                        // ErrorRateThreshold is only checked once a node ran BreakerMinRequests transactions in the period
+	OnNodeStateChange   func(NodeEvent)            // if not nil, called when a node goes down, starts recovering or is up again. It must not block
+	MetricsCollector    MetricsCollector           // receives the events of the pool to build metrics
+	Logger              Logger                     // receives the log messages of the pool, glog by default
+	SpeculativeDelay    time.Duration              // if not 0, Get and MultiGet also send the read to the next node when the first one did not answer after SpeculativeDelay
+	SpeculativeQuantile float64                    // if not 0, use this quantile (0 to 1) of the recent Get and MultiGet latencies as SpeculativeDelay once enough are known
+	OperationTracer     OperationTracer            // if not nil, receives the start and end of every operation and of every attempt to run it
+	Limits              Limits                     // throttles the operations of the pool, not limited by default
+	MaxConnectionAge    time.Duration              // if not 0, close the connections once they have been open for MaxConnectionAge
+	MaxConnectionUses   int                        // if not 0, close the connections once they ran MaxConnectionUses transactions
+	MinIdle             int                        // open connections when the pool is created, and every BleederInterval, to keep at least MinIdle idle connections PER NODE, it must be lower than Size
+	KeepaliveInterval   time.Duration              // if not 0, check the connections idle for KeepaliveInterval and close the ones that do not answer
+	CredentialsProvider CredentialsProvider        // if not nil, used instead of Authentication and asked for the credentials of every new connection
+	TLSConfigProvider   TLSConfigProvider          // if not nil, used instead of TLSConfig and asked for the configuration of every new connection
+	SRVName             string                     // if set, the targets of the SRV records of SRVName, like "_cassandra._tcp.example.com", are used as nodes too
+	ResolveInterval     time.Duration              // if not 0, resolve the host names of the nodes, and SRVName, again every ResolveInterval
 }
 
 var DefaultPoolOptions = PoolOptions{
-	Size:             10,
-	ReadConsistency:  CONSISTENCY_QUORUM,
-	WriteConsistency: CONSISTENCY_QUORUM,
-	Timeout:          time.Second * 1,
-	BleederInterval:  time.Second * 2,
-	Grace:            5,
-	Retries:          5,
+	Size:                10,
+	ReadConsistency:     CONSISTENCY_QUORUM,
+	WriteConsistency:    CONSISTENCY_QUORUM,
+	Timeout:             time.Second * 1,
+	BleederInterval:     time.Second * 2,
+	Grace:               5,
+	Retries:             5,
+	RetryPolicy:         NewSimpleRetryPolicy(),
+	RefreshInterval:     time.Minute,
+	LoadBalancingPolicy: NewRandomPolicy(),
+	AcquireTimeout:      time.Second * 1,
+	CloseTimeout:        time.Second * 5,
+	BreakerWindow:       time.Second * 10,
+	BreakerMinRequests:  10,
+	MetricsCollector:    nopCollector{},
+	Logger:              NewGlogLogger(),
 	// Authentication is empty
 	// TLSConfig is empty
 }
@@ -103,6 +185,8 @@ const (
 
 var (
 	ErrorConnectionTimeout = errors.New("Connection timeout")
+	ErrPoolExhausted       = errors.New("Timeout while waiting for an available connection")
+	ErrPoolClosed          = errors.New("Connection pool is closed")
 )
 
 func (o *PoolOptions) mergeFrom(r *PoolOptions) {
@@ -131,26 +215,132 @@ func (o *PoolOptions) mergeFrom(r *PoolOptions) {
 	if r.Retries != 0 {
 		o.Retries = r.Retries
 	}
+	if r.RetryPolicy != nil {
+		o.RetryPolicy = r.RetryPolicy
+	}
 	if r.Authentication != nil {
 		o.Authentication = r.Authentication
 	}
 	if r.TLSConfig != nil {
 		o.TLSConfig = r.TLSConfig
 	}
+	if r.DiscoverNodes {
+		o.DiscoverNodes = r.DiscoverNodes
+	}
+	if r.RefreshInterval != 0 {
+		o.RefreshInterval = r.RefreshInterval
+	}
+	if r.LocalDatacenter != "" {
+		o.LocalDatacenter = r.LocalDatacenter
+	}
+	if r.RemoteNodes != 0 {
+		o.RemoteNodes = r.RemoteNodes
+	}
+	if r.LoadBalancingPolicy != nil {
+		o.LoadBalancingPolicy = r.LoadBalancingPolicy
+	}
+	if r.MaxConnections != 0 {
+		o.MaxConnections = r.MaxConnections
+	}
+	if r.AcquireTimeout != 0 {
+		o.AcquireTimeout = r.AcquireTimeout
+	}
+	if r.CloseTimeout != 0 {
+		o.CloseTimeout = r.CloseTimeout
+	}
+	if r.HealthCheckInterval != 0 {
+		o.HealthCheckInterval = r.HealthCheckInterval
+	}
+	if r.ErrorRateThreshold != 0 {
+		o.ErrorRateThreshold = r.ErrorRateThreshold
+	}
+	if r.LatencyThreshold != 0 {
+		o.LatencyThreshold = r.LatencyThreshold
+	}
+	if r.BreakerWindow != 0 {
+		o.BreakerWindow = r.BreakerWindow
+	}
+	if r.BreakerMinRequests != 0 {
+		o.BreakerMinRequests = r.BreakerMinRequests
+	}
+	if r.OnNodeStateChange != nil {
+		o.OnNodeStateChange = r.OnNodeStateChange
+	}
+	if r.MetricsCollector != nil {
+		o.MetricsCollector = r.MetricsCollector
+	}
+	if r.Logger != nil {
+		o.Logger = r.Logger
+	}
+	if r.SpeculativeDelay != 0 {
+		o.SpeculativeDelay = r.SpeculativeDelay
+	}
+	if r.SpeculativeQuantile != 0 {
+		o.SpeculativeQuantile = r.SpeculativeQuantile
+	}
+	if r.OperationTracer != nil {
+		o.OperationTracer = r.OperationTracer
+	}
+	if r.Limits != (Limits{}) {
+		o.Limits = r.Limits
+	}
+	if r.MaxConnectionAge != 0 {
+		o.MaxConnectionAge = r.MaxConnectionAge
+	}
+	if r.MaxConnectionUses != 0 {
+		o.MaxConnectionUses = r.MaxConnectionUses
+	}
+	if r.MinIdle != 0 {
+		o.MinIdle = r.MinIdle
+	}
+	if r.KeepaliveInterval != 0 {
+		o.KeepaliveInterval = r.KeepaliveInterval
+	}
+	if r.CredentialsProvider != nil {
+		o.CredentialsProvider = r.CredentialsProvider
+	}
+	if r.TLSConfigProvider != nil {
+		o.TLSConfigProvider = r.TLSConfigProvider
+	}
+	if r.SRVName != "" {
+		o.SRVName = r.SRVName
+	}
+	if r.ResolveInterval != 0 {
+		o.ResolveInterval = r.ResolveInterval
+	}
 }
 
 type node struct {
-	lastFailure int
-	node        string
+	stats counters // first to be 64-bit aligned for atomic access
+	breaker
+	node        string // "ip:port" address when it was resolved from a host name
+	name        string // host name the node was resolved from, empty if configured by address
 	available   lifo
+	removed     int32
+	outstanding int32
+	open        int32         // open connections
+	slots       chan struct{} // one element per open connection, nil if there is no limit
+	released    chan struct{} // signaled when a connection is returned to available
+}
+
+func newNode(addr string, maxConnections int) *node {
+	n := &node{
+		node:     addr,
+		released: make(chan struct{}, 1),
+	}
+	if maxConnections > 0 {
+		n.slots = make(chan struct{}, maxConnections)
+	}
+	return n
 }
 
 type connectionPool struct {
 	keyspace string
 	options  PoolOptions
 	schema   *Schema
-	nodes    []*node
+	cluster  *cluster
 	tracer   Tracer
+	limiter  *limiter
 }
 
 var nowfunc func() time.Time = time.Now
@@ -158,20 +348,29 @@ var nowfunc func() time.Time = time.Now
 // NewConnectionPool creates a new connection pool for the given nodes and keyspace.
 // nodes is in the format of "host:port" strings.
 func NewConnectionPool(nodes []string, keyspace string, options PoolOptions) (ConnectionPool, error) {
-	if len(nodes) <= 0 {
+	if len(nodes) <= 0 && options.SRVName == "" {
 		return nil, errors.New("At least one node is required")
 	}
 
 	cp := &connectionPool{
 		keyspace: keyspace,
 		options:  DefaultPoolOptions,
-		nodes:    make([]*node, len(nodes)),
 	}
 	cp.options.mergeFrom(&options)
-
-	for i, n := range nodes {
-		cp.nodes[i] = &node{node: n}
+	seeds := cp.resolveSeeds(nodes)
+	if len(seeds) == 0 {
+		return nil, errors.New("At least one node is required")
+	}
+	addrs := make([]string, len(seeds))
+	for i, s := range seeds {
+		addrs[i] = s.addr
 	}
+	cp.cluster = newCluster(addrs, cp.options.MaxConnections)
+	cp.cluster.seeds = append([]string(nil), nodes...)
+	for i, s := range seeds {
+		cp.cluster.nodes[i].name = s.name
+	}
+	cp.limiter = newLimiter(cp.options.Limits)
 
 	var ksDef *cassandra.KsDef
 	err := cp.run(func(c *connection) error {
@@ -181,18 +380,39 @@ func NewConnectionPool(nodes []string, keyspace string, options PoolOptions) (Co
 	})
 
 	if err != nil {
+		cp.Close()
 		return nil, err
 	}
 
 	if ksDef == nil {
+		cp.Close()
 		return nil, errors.New("Keyspace not found while trying to parse schema")
 	}
 
 	cp.schema = newSchema(ksDef)
 	if cp.schema == nil {
+		cp.Close()
 		return nil, errors.New("Cannot parse schema")
 	}
-	go cp.bleeder(options.BleederInterval)
+
+	if err = cp.refreshRing(); err != nil {
+		cp.options.Logger.Warn("Token aware routing disabled", "error", err)
+	}
+	cp.warmUp()
+	go cp.bleeder(cp.options.BleederInterval)
+	if cp.options.KeepaliveInterval > 0 {
+		go cp.keepalive(cp.options.KeepaliveInterval)
+	}
+	go cp.credentialsWatcher()
+	if cp.options.ResolveInterval > 0 {
+		go cp.resolver(cp.options.ResolveInterval)
+	}
+	if cp.options.RefreshInterval > 0 {
+		go cp.refresher(cp.options.RefreshInterval)
+	}
+	if cp.options.HealthCheckInterval > 0 {
+		go cp.healthChecker(cp.options.HealthCheckInterval)
+	}
 
 	return cp, nil
 }
@@ -202,21 +422,87 @@ func (cp *connectionPool) WithTracer(tracer Tracer) ConnectionPool {
 		keyspace: cp.keyspace,
 		options:  cp.options,
 		schema:   cp.schema,
-		nodes:    cp.nodes,
+		cluster:  cp.cluster,
 		tracer:   tracer,
+		limiter:  cp.limiter,
+	}
+}
+
+func (cp *connectionPool) WithLimits(limits Limits) ConnectionPool {
+	options := cp.options
+	options.Limits = limits
+	return &connectionPool{
+		keyspace: cp.keyspace,
+		options:  options,
+		schema:   cp.schema,
+		cluster:  cp.cluster,
+		tracer:   cp.tracer,
+		limiter:  newLimiter(limits),
+	}
+}
+
+// refreshRing asks the cluster for its partitioner and token ring so requests can be routed
+// straight to a replica of their row key. If DiscoverNodes is set the nodes of the ring are
+// added to the node list and the ones that left it removed, otherwise the list is not changed.
+func (cp *connectionPool) refreshRing() error {
+	var class string
+	var ranges []*cassandra.TokenRange
+	err := cp.run(func(c *connection) error {
+		var err error
+		if class, err = c.client.DescribePartitioner(); err != nil {
+			return err
+		}
+		ranges, err = c.client.DescribeRing(cp.keyspace)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	current, _ := cp.cluster.get()
+	nodes := current
+	var added, removed []*node
+	if cp.options.DiscoverNodes {
+		nodes, removed = discoverNodes(current, cp.cluster.knownDatacenters(), ranges, cp.options.MaxConnections)
+		// the new nodes are appended after the kept ones
+		added = nodes[len(current)-len(removed):]
+	}
+
+	// the hosts are resolved without holding the lock, so the discovered nodes are merged into
+	// the current ones, which AddNode, RemoveNode or the resolver may have changed meanwhile
+	p := newPartitioner(class)
+	removed, err = cp.cluster.update(p, ranges, hostIndex(nodes), added, removed)
+	if p == nil {
+		err = errors.New("Unsupported partitioner " + class)
 	}
+
+	for _, n := range removed {
+		cp.options.Logger.Info("Node left the ring, removing it", "node", n.node)
+		n.remove()
+	}
+	return err
 }
 
 func (cp *connectionPool) bleeder(d time.Duration) {
-	l := len(cp.nodes)
-	c := time.Tick(d)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
 	nodeidx := -1
-	for _ = range c {
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-ticker.C:
+		}
+		cp.expireIdle(nowfunc())
+		cp.warmUp()
+		nodes, _ := cp.cluster.get()
+		l := len(nodes)
 		for i := 0; i < l; i++ {
 			nodeidx++
-			if c, ok := cp.nodes[nodeidx%l].available.PopBottom(cp.options.Size); ok {
-				glog.V(1).Info("Closing connection to ", cp.nodes[nodeidx%l].node)
+			if c, ok := nodes[nodeidx%l].available.PopBottom(cp.options.Size); ok {
+				cp.options.Logger.Debug("Closing idle connection", "node", c.node.node)
 				c.close()
+				cp.options.MetricsCollector.ConnectionBled(c.node.node)
 				break
 			}
 		}
@@ -225,118 +511,392 @@ func (cp *connectionPool) bleeder(d time.Duration) {
 
 type transaction func(*connection) error
 
+// operation describes how the pool runs a transaction
+type operation struct {
+	key         []byte                     // if not nil the transaction is sent to the replicas of key first
+	replicas    []*node                    // if not nil the transaction is sent to these nodes first, instead of the replicas of key
+	consistency cassandra.ConsistencyLevel // consistency level for the first attempt, see connection.consistency
+	idempotent  bool                       // running the transaction more than once has the same effect as running it once
+	retries     int                        // maximum number of attempts, 0 uses the pool default
+	speculative bool                       // the transaction can be run on two nodes at the same time, see PoolOptions.SpeculativeDelay
+	spec        *speculation               // set while running a speculative transaction
+	trace       *traceSession              // if not nil the transaction is traced by Cassandra, see Reader.Trace
+	mutations   int                        // number of mutations the transaction writes, see Limits.MutationsPerSecond
+}
+
 func (cp *connectionPool) run(t transaction) error {
 	return cp.runWithRetries(t, cp.options.Retries)
 }
 
 func (cp *connectionPool) runWithRetries(t transaction, retries int) error {
+	return cp.runOperation(context.Background(), &operation{idempotent: true, retries: retries}, t)
+}
+
+// runOperation runs the transaction walking the query plan for the row key of the operation,
+// which starts with the replicas of the key if it is not nil and the token ring is known. Failed
+// attempts are retried as decided by the RetryPolicy. If ctx is done while waiting for a
+// connection, between retries or while the transaction is running the connection is discarded
+// and ctx.Err() is returned.
+func (cp *connectionPool) runOperation(ctx context.Context, op *operation, t transaction) (err error) {
+	if err := cp.limiter.wait(ctx, op); err != nil {
+		return err
+	}
+	defer cp.limiter.done()
+	if err := cp.cluster.begin(); err != nil {
+		return err
+	}
+	defer cp.cluster.end()
+	defer func(start time.Time) {
+		atomic.AddUint64(&cp.cluster.stats.operations, 1)
+		if err != nil {
+			atomic.AddUint64(&cp.cluster.stats.errors, 1)
+		}
+		cp.options.MetricsCollector.OperationDone(time.Since(start), err)
+	}(time.Now())
+
+	if op.trace != nil {
+		t = op.trace.wrap(t)
+	}
+	var plan *queryPlan
+	if op.replicas != nil {
+		plan = cp.replicaQueryPlan(op.replicas, int(nowfunc().Unix()))
+	} else {
+		plan = cp.newQueryPlan(op.key, int(nowfunc().Unix()))
+	}
+	if op.speculative {
+		if delay := cp.speculativeDelay(); delay > 0 {
+			return cp.runSpeculative(ctx, op, plan, t, delay)
+		}
+	}
+	return cp.runAttempts(ctx, op, plan, t)
+}
+
+// runAttempts runs the transaction until it succeeds, the RetryPolicy gives up or the retries
+// are exhausted
+func (cp *connectionPool) runAttempts(ctx context.Context, op *operation, plan *queryPlan, t transaction) (err error) {
 	var c *connection
-	var err error
+	var attempts []Attempt
+	retries := op.retries
+	if retries <= 0 {
+		retries = cp.options.Retries
+	}
+	consistency := op.consistency
 
 	for tries := 0; tries < retries; tries++ {
 
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !op.spec.attempt(retries) {
+			// the other attempts of the speculative transaction used the retries
+			break
+		}
+
 		// acquire a new connection if we are just starting out or after discarding one
 		if c == nil {
-			c, err = cp.acquire()
+			c, err = cp.acquireFrom(ctx, plan)
 			// nothing to do, cannot acquire a connection
 			if err != nil {
-				glog.Error("Unable to acquire cassandra connection: ", err)
+				cp.options.Logger.Error("Unable to acquire cassandra connection", "error", err)
+				if e, ok := err.(*NoHostAvailableError); ok {
+					e.Attempts = append(attempts, e.Attempts...)
+				}
 				return err
 			}
 		}
 
-		tc := c
-		if cp.tracer != nil {
-			tc = &connection{
-				node:      c.node,
-				client:    cp.tracer(cp, c.client),
-				transport: c.transport,
+		c.consistency, c.spec = consistency, op.spec
+		atomic.AddInt32(&c.node.outstanding, 1)
+		end := cp.startAttempt(ctx, tries+1, c.node.node, consistency)
+		stop := c.watch(ctx, cp.options.Timeout)
+		start := time.Now()
+		err = cp.runTransaction(t, c)
+		latency := time.Since(start)
+		interrupted := stop()
+		atomic.AddInt32(&c.node.outstanding, -1)
+
+		if interrupted {
+			// the socket was closed under the transaction, the connection is not usable anymore.
+			// A transaction that completed before the interruption still succeeded.
+			c.close()
+			if err != nil {
+				c.node.endTrial()
+				end(ctx.Err())
+				return ctx.Err()
 			}
+			end(nil)
+			cp.report(c.node, nil, latency)
+			return nil
+		}
+		if p, ok := err.(*PanicError); ok {
+			// a bug in the transaction, not a failure of the node. It is not retried and the
+			// connection may be in any state
+			cp.options.Logger.Error("Transaction panicked", "node", c.node.node, "error", err, "stack", string(p.Stack))
+			c.close()
+			c.node.endTrial()
+			end(err)
+			return err
+		}
+		if err != nil && ctx.Err() != nil {
+			// the socket timeout is the deadline of ctx, so it may have expired right before the
+			// socket was interrupted. The failure is not counted against the node.
+			c.close()
+			c.node.endTrial()
+			end(ctx.Err())
+			return ctx.Err()
 		}
-		err = t(tc)
+		end(err)
+		cp.report(c.node, err, latency)
 
 		if err != nil {
+			n := c.node
+			atomic.AddUint64(&n.stats.failures, 1)
+			attempts = append(attempts, Attempt{Node: n.node, Err: attemptError(n.node, err)})
 			switch err.(type) {
 			case *cassandra.InvalidRequestException:
 				// nonrecoverable error, but not related to availability, do not retry and pass it to the user
-				glog.Errorf("Node %s Invalid request: %s", c.node.node, err.(*cassandra.InvalidRequestException).Why)
+				cp.options.Logger.Error("Invalid request", "node", n.node, "why", err.(*cassandra.InvalidRequestException).Why)
 				cp.release(c)
 				return err
 			case *cassandra.TimedOutException:
 				// the node is timing out. This Is Bad. move it to the blacklist and try again with another connection
-				glog.Infof("Node %s %s, blacklisted", c.node.node, err)
-				c.node.blacklist()
+				cp.options.Logger.Info("Node timed out, blacklisted", "node", n.node, "error", err)
+				cp.blacklist(c.node, err)
 				c.close()
-				c = nil
-				continue
 			case *cassandra.UnavailableException:
 				// one or more replicas are unavailable for the operation at the required consistency level. this is potentially
 				// recoverable in a partitioned cluster by hoping to another connection/node and trying again
-				glog.Info("Node %s %s", c.node.node, err)
+				cp.options.Logger.Info("Not enough replicas available", "node", n.node, "error", err)
 				cp.release(c)
-				c = nil
-				continue
 			default:
 				// nonrecoverable error, drop the connection (but do not blacklist) and retry
-				glog.Errorf("Node %s error %s", c.node.node, err)
+				cp.options.Logger.Error("Node error", "node", n.node, "error", err)
 				c.close()
-				c = nil
-				continue
 			}
+			c = nil
+
+			decision, delay := cp.options.RetryPolicy.OnFailure(RetryInfo{
+				Attempt:     tries + 1,
+				Err:         err,
+				Failure:     failureType(err),
+				Idempotent:  op.idempotent,
+				Consistency: consistency,
+			})
+			switch decision {
+			case Rethrow:
+				return &RetriesExhaustedError{Attempts: attempts}
+			case RetryLowerConsistency:
+				consistency = LowerConsistency(consistency)
+			}
+			if tries+1 < retries && !op.spec.exhausted(retries) {
+				atomic.AddUint64(&cp.cluster.stats.retries, 1)
+				cp.options.MetricsCollector.Retried(n.node, err)
+				if err := sleep(ctx, delay); err != nil {
+					return err
+				}
+			}
+			continue
 		}
 
 		// no errors, release connection and return
+		if op.speculative {
+			cp.cluster.latencies.add(latency)
+		}
 		cp.release(c)
 		return nil
 
 	}
 
 	// loop exited normally so it hit the retry limit
-	return fmt.Errorf("Max retries hit trying to run a Cassandra transaction. Last error: %v", err)
+	return &RetriesExhaustedError{Attempts: attempts}
 }
 
-func (cp *connectionPool) randomNode(now int) (*node, error) {
-	n := len(cp.nodes)
-	i := rand.Int() % n
-
-	for tries := 0; tries < n; tries++ {
-		nodei := cp.nodes[i]
-		if nodei.lastFailure+cp.options.Grace < now {
-			return nodei, nil
+// splitByReplica groups the keys by the first replica that owns them, the first one in the
+// local datacenter if PoolOptions.LocalDatacenter is set, like the query plan of the key. All the
+// keys are returned in a single group if the ring is unknown.
+func (cp *connectionPool) splitByReplica(keys [][]byte) [][][]byte {
+	_, ring := cp.cluster.get()
+	if ring == nil || len(keys) <= 1 {
+		return [][][]byte{keys}
+	}
+	var groups [][][]byte
+	index := make(map[*node]int)
+	for _, key := range keys {
+		var n *node
+		replicas := ring.replicasFor(key)
+		if local := cp.cluster.local(replicas, cp.options.LocalDatacenter); len(local) > 0 {
+			n = local[0]
+		} else if len(replicas) > 0 {
+			n = replicas[0]
+		}
+		i, ok := index[n]
+		if !ok {
+			i = len(groups)
+			index[n] = i
+			groups = append(groups, nil)
 		}
-		i = (i + 1) % n
+		groups[i] = append(groups[i], key)
 	}
-
-	//TODO: try to acquire one anyway
-	return nil, errors.New("All nodes are marked down, cannot acquire new connection")
+	return groups
 }
 
 func (cp *connectionPool) acquire() (*connection, error) {
+	return cp.acquireFrom(context.Background(), cp.newQueryPlan(nil, int(nowfunc().Unix())))
+}
+
+// acquireFrom returns a connection to the next node of the query plan. A node without a free
+// connection is skipped for the next one, ErrPoolExhausted is returned once all of them were.
+// The waits for a free connection of all the nodes share a single AcquireTimeout.
+func (cp *connectionPool) acquireFrom(ctx context.Context, plan *queryPlan) (*connection, error) {
 
 	now := int(nowfunc().Unix())
-	n, err := cp.randomNode(now)
-	if err != nil {
-		return nil, err
+	deadline := time.Now().Add(cp.options.AcquireTimeout)
+	var attempts []Attempt
+	exhausted := make(map[*node]bool)
+	for {
+		n := plan.nextNode(cp, now)
+		if n == nil || exhausted[n] {
+			//TODO: try to acquire one anyway
+			return nil, &NoHostAvailableError{Attempts: attempts}
+		}
+		start := time.Now()
+		c, err := cp.acquireNode(ctx, n, deadline)
+		if err != nil {
+			n.endTrial()
+			if ctx.Err() != nil {
+				// the caller gave up, the node did not fail
+				return nil, ctx.Err()
+			}
+		}
+		if err == ErrPoolExhausted {
+			attempts = append(attempts, Attempt{Node: n.node, Err: err})
+			exhausted[n] = true
+			continue
+		}
+		if err != nil {
+			attempts = append(attempts, Attempt{Node: n.node, Err: attemptError(n.node, err)})
+			return nil, &NoHostAvailableError{Attempts: attempts}
+		}
+		atomic.AddUint64(&n.stats.acquired, 1)
+		cp.options.MetricsCollector.ConnectionAcquired(n.node, time.Since(start))
+		return c, nil
 	}
+}
+
+// acquireNode returns an idle connection to the node or opens a new one, waiting until deadline
+// for a free connection if the node has MaxConnections open
+func (cp *connectionPool) acquireNode(ctx context.Context, n *node, deadline time.Time) (*connection, error) {
 	c, ok := n.available.Pop()
 	if ok {
 		return c, nil
 	}
-	c, err = newConnection(n, cp.keyspace, cp.options.Timeout, cp.options.Authentication, cp.options.TLSConfig)
-	if err == ErrorConnectionTimeout {
-		n.blacklist()
+	if n.slots != nil {
+		c, err := cp.waitSlot(ctx, n, deadline)
+		if c != nil || err != nil {
+			return c, err
+		}
+	}
+	c, err := cp.dial(n, timeoutFor(ctx, cp.options.Timeout))
+	if err != nil {
+		n.freeSlot()
+		switch _, provider := err.(*ProviderError); {
+		case provider, ctx.Err() != nil:
+			// not a problem of the node, or the deadline of ctx cut the dial short
+		case err == ErrorConnectionTimeout:
+			cp.blacklist(n, err)
+		default:
+			cp.report(n, err, 0)
+		}
+		return nil, err
 	}
+	c.slot = n.slots != nil
 	return c, err
 }
 
+// waitSlot blocks until the node has room for a new connection, returning nil, or until an idle
+// connection is released to it, returning that connection. It gives up at deadline or when ctx
+// is done.
+func (cp *connectionPool) waitSlot(ctx context.Context, n *node, deadline time.Time) (*connection, error) {
+	// a free slot is taken even if the deadline already passed
+	select {
+	case n.slots <- struct{}{}:
+		return nil, nil
+	default:
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		select {
+		case n.slots <- struct{}{}:
+			return nil, nil
+		case <-n.released:
+			if c, ok := n.available.Pop(); ok {
+				// pass the signal on if more connections were released meanwhile
+				if n.available.Len() > 0 {
+					n.signalRelease()
+				}
+				return c, nil
+			}
+		case <-timer.C:
+			return nil, ErrPoolExhausted
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
 func (cp *connectionPool) release(c *connection) {
+	c.uses++
+	c.lastUsed = nowfunc()
+	if cp.putBack(c, c.lastUsed) {
+		cp.options.MetricsCollector.ConnectionReleased(c.node.node)
+	} else {
+		cp.options.MetricsCollector.ConnectionExpired(c.node.node)
+	}
+}
+
+// putBack returns the connection to the idle ones of its node, or closes it and returns false if
+// it expired
+func (cp *connectionPool) putBack(c *connection, now time.Time) bool {
+	if cp.expired(c, now) {
+		cp.options.Logger.Debug("Closing expired connection", "node", c.node.node, "age", now.Sub(c.created), "uses", c.uses)
+		c.close()
+		return false
+	}
 	c.node.available.Push(c)
+	c.node.signalRelease()
+	// the node may have left the pool while the connection was in use
+	if c.node.isRemoved() {
+		c.node.closeIdle()
+	}
+	return true
+}
+
+func (n *node) signalRelease() {
+	select {
+	case n.released <- struct{}{}:
+	default:
+	}
+}
+
+func (n *node) freeSlot() {
+	if n.slots != nil {
+		<-n.slots
+	}
 }
 
-func (n *node) blacklist() {
-	n.lastFailure = int(nowfunc().Unix())
+// blacklist takes the node down and closes its idle connections. It returns the previous state
+// of the node.
+func (n *node) blacklist() NodeState {
+	n.m.Lock()
+	from := n.setState(NodeDown)
+	n.m.Unlock()
 	//close all connections
-	glog.V(1).Info("closing %d connections to blacklisted node %s", len(n.available.l), n.node)
+	n.closeIdle()
+	return from
+}
+
+func (n *node) closeIdle() {
 	for c, ok := n.available.Pop(); ok; c, ok = n.available.Pop() {
 		c.close()
 	}
@@ -367,12 +927,85 @@ func (cp *connectionPool) Schema() *Schema {
 }
 
 func (cp *connectionPool) Close() {
+	if !cp.cluster.drain(cp.options.CloseTimeout) {
+		cp.options.Logger.Warn("Closing connection pool with transactions still running")
+	}
+	nodes, _ := cp.cluster.get()
+	for _, n := range nodes {
+		n.remove()
+	}
 }
 
 type connection struct {
 	transport *thrift.TFramedTransport
+	socket    socket
 	client    cassandra.Cassandra
 	node      *node
+	slot      bool // holds one of the node slots
+	counted   bool // counted in the open connections of the node
+	created   time.Time
+	lastUsed  time.Time
+	uses      int // transactions run, see PoolOptions.MaxConnectionUses
+	// generation of the connections of the pool when it was opened, see recycle
+	generation int32
+
+	// consistency is the level transactions must use, it can be lower than the requested one
+	// after a RetryLowerConsistency decision
+	consistency cassandra.ConsistencyLevel
+
+	// spec is set when the transaction may be running on another connection too, see commit
+	spec *speculation
+}
+
+// socket is the part of thrift.TSocket and thrift.TSSLSocket used to apply contexts
+type socket interface {
+	SetTimeout(timeout time.Duration) error
+	Interrupt() error
+}
+
+// timeoutFor returns timeout, or the time left until the deadline of ctx if it is sooner
+func timeoutFor(ctx context.Context, timeout time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		left := deadline.Sub(time.Now())
+		if left <= 0 {
+			// a zero timeout would mean no timeout at all
+			left = time.Nanosecond
+		}
+		if left < timeout {
+			return left
+		}
+	}
+	return timeout
+}
+
+// watch applies the deadline of ctx to the socket and interrupts it if ctx is done before the
+// returned function is called. That function restores the socket timeout and reports if the
+// socket was interrupted.
+func (c *connection) watch(ctx context.Context, timeout time.Duration) func() bool {
+	if ctx.Done() == nil || c.socket == nil {
+		return func() bool { return false }
+	}
+	if t := timeoutFor(ctx, timeout); t != timeout {
+		c.socket.SetTimeout(t)
+	}
+	interrupted := false
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			interrupted = true
+			c.socket.Interrupt()
+		case <-done:
+		}
+	}()
+	return func() bool {
+		close(done)
+		<-exited
+		c.socket.SetTimeout(timeout)
+		return interrupted
+	}
 }
 
 func newConnection(n *node, keyspace string, timeout time.Duration, authentication map[string]string, tlsConfig *tls.Config) (*connection, error) {
@@ -386,9 +1019,11 @@ func newConnection(n *node, keyspace string, timeout time.Duration, authenticati
 	if tlsConfig == nil {
 		socket := thrift.NewTSocketFromAddrTimeout(addr, timeout)
 		c.transport = thrift.NewTFramedTransport(socket)
+		c.socket = socket
 	} else {
 		sslSocket := thrift.NewTSSLSocketFromAddrTimeout(addr, tlsConfig, timeout)
 		c.transport = thrift.NewTFramedTransport(sslSocket)
+		c.socket = sslSocket
 	}
 
 	protocol := thrift.NewTBinaryProtocolTransport(c.transport)
@@ -443,9 +1078,21 @@ func newConnection(n *node, keyspace string, timeout time.Duration, authenticati
 		return nil, err
 	}
 
+	c.counted = true
+	atomic.AddInt32(&n.open, 1)
+	c.created = nowfunc()
+	c.lastUsed = c.created
 	return c, nil
 }
 
 func (c *connection) close() {
 	c.transport.Close()
+	if c.counted {
+		c.counted = false
+		atomic.AddInt32(&c.node.open, -1)
+	}
+	if c.slot {
+		c.slot = false
+		c.node.freeSlot()
+	}
 }
@@ -1,10 +1,13 @@
 package gossie
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/stretchr/testify/assert"
 	"github.com/wadey/gossie/src/cassandra"
 )
@@ -123,7 +126,7 @@ func TestAcquireRelease(t *testing.T) {
 		t.Fatal("Error connecting to Cassandra:", err)
 	}
 	cp := cpI.(*connectionPool)
-	n := cp.nodes[0]
+	n := cp.cluster.nodes[0]
 
 	assert.Equal(t, len(n.available.l), 1)
 	assert.NoError(t, err)
@@ -198,3 +201,129 @@ func TestRun(t *testing.T) {
 	check(false, false, true, false, true)
 	check(false, false, false, true, true)
 }
+
+func TestWaitSlot(t *testing.T) {
+	n := newNode(localEndpoint, 1)
+	options := DefaultPoolOptions
+	options.MaxConnections = 1
+	options.AcquireTimeout = 10 * time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	c, err := cp.waitSlot(context.Background(), n, time.Now().Add(cp.options.AcquireTimeout))
+	assert.Nil(t, c)
+	assert.NoError(t, err)
+
+	c, err = cp.waitSlot(context.Background(), n, time.Now().Add(cp.options.AcquireTimeout))
+	assert.Nil(t, c)
+	assert.Equal(t, ErrPoolExhausted, err)
+
+	released := &connection{node: n, slot: true}
+	go func() {
+		time.Sleep(time.Millisecond)
+		cp.release(released)
+	}()
+	cp.options.AcquireTimeout = time.Second
+	c, err = cp.waitSlot(context.Background(), n, time.Now().Add(cp.options.AcquireTimeout))
+	assert.NoError(t, err)
+	assert.Equal(t, released, c)
+
+	n.freeSlot()
+	c, err = cp.waitSlot(context.Background(), n, time.Now().Add(cp.options.AcquireTimeout))
+	assert.Nil(t, c)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, err = cp.waitSlot(ctx, n, time.Now().Add(cp.options.AcquireTimeout))
+	assert.Nil(t, c)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestAcquireExhausted(t *testing.T) {
+	n1 := newNode("10.0.0.1:9160", 1)
+	n2 := newNode("10.0.0.2:9160", 1)
+	n1.slots <- struct{}{}
+	n2.slots <- struct{}{}
+	options := DefaultPoolOptions
+	options.AcquireTimeout = time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+
+	// the node with a free connection is used whatever its place in the plan
+	idle := &connection{node: n2, slot: true}
+	n2.available.Push(idle)
+	c, err := cp.acquireFrom(context.Background(), cp.newQueryPlan(nil, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, idle, c)
+
+	_, err = cp.acquireFrom(context.Background(), cp.newQueryPlan(nil, 0))
+	var noHost *NoHostAvailableError
+	assert.True(t, errors.As(err, &noHost))
+	assert.Equal(t, 2, len(noHost.Attempts))
+	assert.True(t, errors.Is(err, ErrPoolExhausted))
+}
+
+func TestAcquireExhaustedTimeout(t *testing.T) {
+	nodes := make([]*node, 3)
+	for i := range nodes {
+		nodes[i] = newNode(fmt.Sprintf("10.0.0.%d:9160", i+1), 1)
+		nodes[i].slots <- struct{}{}
+	}
+	options := DefaultPoolOptions
+	options.AcquireTimeout = 100 * time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: nodes}, options: options}
+
+	// a single AcquireTimeout is waited for all the nodes
+	start := time.Now()
+	_, err := cp.acquireFrom(context.Background(), cp.newQueryPlan(nil, 0))
+	assert.True(t, errors.Is(err, ErrPoolExhausted))
+	assert.True(t, time.Since(start) < 2*options.AcquireTimeout, time.Since(start))
+}
+
+func TestTimeoutFor(t *testing.T) {
+	assert.Equal(t, time.Second, timeoutFor(context.Background(), time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.Equal(t, time.Second, timeoutFor(ctx, time.Second))
+	assert.True(t, timeoutFor(ctx, time.Hour) <= time.Minute)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	assert.True(t, timeoutFor(ctx, time.Second) > 0)
+}
+
+func TestRunInterruptedAfterSuccess(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	n.available.Push(newTestConnection(n))
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := cp.runOperation(ctx, &operation{}, func(c *connection) error {
+		cancel()
+		<-c.socket.(*testSocket).interrupted
+		return nil
+	})
+	assert.NoError(t, err)
+	// the interrupted connection is not reused
+	assert.Equal(t, 0, n.available.Len())
+}
+
+func TestRunContextSocketTimeout(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.ErrorRateThreshold = 0.1
+
+	// the socket times out together with ctx, before or after the connection is interrupted
+	for i := 0; i < 20; i++ {
+		n.available.Push(newTestConnection(n))
+		ctx, cancel := context.WithCancel(context.Background())
+		err := cp.runOperation(ctx, &operation{}, func(c *connection) error {
+			cancel()
+			return thrift.NewTTransportException(thrift.TIMED_OUT, "i/o timeout")
+		})
+		assert.Equal(t, context.Canceled, err)
+	}
+	// the node is not blamed for the deadline of the caller
+	assert.Equal(t, uint64(0), n.stats.failures)
+	assert.Equal(t, NodeUp, n.getState())
+}
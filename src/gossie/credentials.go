@@ -0,0 +1,198 @@
+package gossie
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CredentialsProvider gives the credentials used to log in on every new connection, instead of
+// the fixed PoolOptions.Authentication
+type CredentialsProvider interface {
+	// Credentials returns the values passed to login(), no login is done if it is empty
+	Credentials() (map[string]string, error)
+
+	// Changed returns a channel that receives a value every time the credentials change, to
+	// recycle the connections logged in with the old ones, or nil if they never change
+	Changed() <-chan struct{}
+}
+
+// TLSConfigProvider gives the TLS configuration used by every new connection, instead of the
+// fixed PoolOptions.TLSConfig
+type TLSConfigProvider interface {
+	// TLSConfig returns the configuration for a new connection, nil to not use TLS
+	TLSConfig() (*tls.Config, error)
+
+	// Changed returns a channel that receives a value every time the configuration changes, to
+	// recycle the connections opened with the old one, or nil if it never changes
+	Changed() <-chan struct{}
+}
+
+// dial opens a new connection to the node with the current credentials and TLS configuration.
+// The errors of the providers are returned as a ProviderError.
+func (cp *connectionPool) dial(n *node, timeout time.Duration) (*connection, error) {
+	generation := atomic.LoadInt32(&cp.cluster.generation)
+	authentication := cp.options.Authentication
+	if cp.options.CredentialsProvider != nil {
+		var err error
+		if authentication, err = cp.options.CredentialsProvider.Credentials(); err != nil {
+			return nil, &ProviderError{Err: err}
+		}
+	}
+	tlsConfig := cp.options.TLSConfig
+	if cp.options.TLSConfigProvider != nil {
+		var err error
+		if tlsConfig, err = cp.options.TLSConfigProvider.TLSConfig(); err != nil {
+			return nil, &ProviderError{Err: err}
+		}
+	}
+	c, err := newConnection(n, cp.keyspace, timeout, authentication, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.generation = generation
+	return c, nil
+}
+
+// recycle makes the current connections expire, the idle ones are closed right away and the
+// ones in use when they are released, so new ones are opened with the current credentials
+func (cp *connectionPool) recycle() {
+	atomic.AddInt32(&cp.cluster.generation, 1)
+	cp.expireIdle(nowfunc())
+}
+
+// credentialsWatcher recycles the connections every time the credentials or the TLS
+// configuration change
+func (cp *connectionPool) credentialsWatcher() {
+	var credentials, tlsConfig <-chan struct{}
+	if cp.options.CredentialsProvider != nil {
+		credentials = cp.options.CredentialsProvider.Changed()
+	}
+	if cp.options.TLSConfigProvider != nil {
+		tlsConfig = cp.options.TLSConfigProvider.Changed()
+	}
+	if credentials == nil && tlsConfig == nil {
+		return
+	}
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-credentials:
+			cp.options.Logger.Info("Credentials changed, recycling connections")
+		case <-tlsConfig:
+			cp.options.Logger.Info("TLS configuration changed, recycling connections")
+		}
+		cp.recycle()
+	}
+}
+
+// CertificateReloader is a TLSConfigProvider that loads a client certificate from a pair of PEM
+// files and loads it again when the files are modified
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	base     *tls.Config
+	config   *tls.Config
+	modTime  time.Time
+	changed  chan struct{}
+	done     chan struct{}
+	m        sync.Mutex
+}
+
+// NewCertificateReloader loads the certificate and key files and checks them for changes every
+// interval until Stop is called. The configuration given to the connections is a copy of base
+// with the certificate.
+func NewCertificateReloader(certFile, keyFile string, base *tls.Config, interval time.Duration) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		base:     base,
+		changed:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go r.reloader(interval)
+	}
+	return r, nil
+}
+
+func (r *CertificateReloader) reloader(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+		r.Reload()
+	}
+}
+
+// Stop stops checking the files for changes
+func (r *CertificateReloader) Stop() {
+	close(r.done)
+}
+
+// Reload loads the certificate again if the files were modified since the last time, returning
+// true if it did
+func (r *CertificateReloader) Reload() (bool, error) {
+	modTime, err := lastModified(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.config != nil && !modTime.After(r.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	config := &tls.Config{}
+	if r.base != nil {
+		config = r.base.Clone()
+	}
+	config.Certificates = []tls.Certificate{cert}
+	first := r.config == nil
+	r.config, r.modTime = config, modTime
+	if !first {
+		select {
+		case r.changed <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+func (r *CertificateReloader) TLSConfig() (*tls.Config, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.config, nil
+}
+
+func (r *CertificateReloader) Changed() <-chan struct{} {
+	return r.changed
+}
+
+// lastModified returns the most recent modification time of the files
+func lastModified(files ...string) (time.Time, error) {
+	var last time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,132 @@
+package gossie
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCredentials struct {
+	credentials map[string]string
+	err         error
+	changed     chan struct{}
+}
+
+func (p *testCredentials) Credentials() (map[string]string, error) { return p.credentials, p.err }
+func (p *testCredentials) Changed() <-chan struct{}                { return p.changed }
+
+func TestRecycle(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	idle := newTestConnection(n)
+	inUse := newTestConnection(n)
+	n.available.Push(idle)
+	provider := &testCredentials{changed: make(chan struct{})}
+	options := DefaultPoolOptions
+	options.CredentialsProvider = provider
+	options.Logger = &testLogger{}
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}, done: make(chan struct{})}, options: options}
+	go cp.credentialsWatcher()
+	defer close(cp.cluster.done)
+
+	provider.changed <- struct{}{}
+	// the idle connection is closed right away
+	for n.available.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cp.cluster.generation))
+	cp.release(inUse)
+	assert.Equal(t, 0, n.available.Len())
+}
+
+func TestDialCredentialsError(t *testing.T) {
+	broken := errors.New("vault is sealed")
+	options := DefaultPoolOptions
+	options.CredentialsProvider = &testCredentials{err: broken}
+	cp := &connectionPool{cluster: &cluster{}, options: options}
+	_, err := cp.dial(newNode(localEndpoint, 0), time.Millisecond)
+	var provider *ProviderError
+	assert.True(t, errors.As(err, &provider))
+	assert.True(t, errors.Is(err, broken))
+
+	// the error of the provider does not count against the node
+	options.ErrorRateThreshold = 0.5
+	options.BreakerMinRequests = 1
+	n := newNode(localEndpoint, 0)
+	cp = &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+	_, err = cp.acquireNode(context.Background(), n, time.Now())
+	assert.True(t, errors.Is(err, broken))
+	assert.Equal(t, NodeUp, n.getState())
+	n.setState(NodeRecovering)
+	_, err = cp.acquireNode(context.Background(), n, time.Now())
+	assert.True(t, errors.Is(err, broken))
+	assert.Equal(t, NodeRecovering, n.getState())
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gossie")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	r, err := NewCertificateReloader(certFile, keyFile, nil, 0)
+	assert.NoError(t, err)
+	config, _ := r.TLSConfig()
+	assert.Equal(t, "first", subject(t, config))
+
+	reloaded, err := r.Reload()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	reloaded, err = r.Reload()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	config, _ = r.TLSConfig()
+	assert.Equal(t, "second", subject(t, config))
+	select {
+	case <-r.Changed():
+	default:
+		t.Error("Changed was not signaled")
+	}
+}
+
+func writeCertificate(t *testing.T, certFile, keyFile, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+func subject(t *testing.T, config *tls.Config) string {
+	cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	assert.NoError(t, err)
+	return cert.Subject.CommonName
+}
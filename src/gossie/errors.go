@@ -0,0 +1,164 @@
+package gossie
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+// Attempt is a failed attempt to run an operation on a node
+type Attempt struct {
+	Node string // "host:port" address of the node
+	Err  error
+}
+
+// RetriesExhaustedError is returned when an operation failed every time the pool ran it, or
+// failed with an error the RetryPolicy rethrows. A PanicError or a
+// *cassandra.InvalidRequestException, which are never retried, is returned as it is instead. It
+// unwraps to the error of the last attempt, so errors.As finds the Thrift exception, like
+// *cassandra.UnavailableException, that made the operation fail.
+type RetriesExhaustedError struct {
+	Attempts []Attempt
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	if len(e.Attempts) == 1 {
+		return fmt.Sprintf("Cassandra transaction failed. Error: %v", e.Unwrap())
+	}
+	return fmt.Sprintf("Max retries hit trying to run a Cassandra transaction. Last error: %v", e.Unwrap())
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// NoHostAvailableError is returned when the pool could not get a connection to run an operation,
+// because all the nodes are down, the connection to the node it picked failed, the nodes had no
+// free connection (ErrPoolExhausted) or the pool was closed (ErrPoolClosed). Attempts holds the
+// failed attempts made before, if any, and last of all the connection error. It unwraps to the
+// error of the last attempt, so errors.Is matches ErrPoolExhausted or ErrPoolClosed. When the
+// context of the operation is done while waiting for a connection its error is returned instead.
+type NoHostAvailableError struct {
+	Attempts []Attempt
+}
+
+func (e *NoHostAvailableError) Error() string {
+	if err := e.Unwrap(); err != nil {
+		return fmt.Sprintf("Unable to acquire a connection to any node. Last error: %v", err)
+	}
+	return "All nodes are marked down, cannot acquire new connection"
+}
+
+func (e *NoHostAvailableError) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// TimeoutError is a node that did not answer in time: it returned a
+// *cassandra.TimedOutException, the socket timed out or connecting to it timed out, in which case
+// Err is ErrorConnectionTimeout. It is never returned alone but found with errors.As in the
+// attempts of RetriesExhaustedError and NoHostAvailableError.
+type TimeoutError struct {
+	Node string
+	Err  error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timeout on node %s: %v", e.Node, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// PartialWriteError is returned by Writer.Run when some of the batches it sent to the replicas
+// failed. The rows of the other batches were written. It unwraps to the error of the first batch
+// that failed.
+type PartialWriteError struct {
+	Failed [][]byte // keys of the rows that may not have been written
+	Err    error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("Mutations of %d rows failed: %v", len(e.Failed), e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// ProviderError is returned when the CredentialsProvider or the TLSConfigProvider of the pool
+// failed to give the settings of a new connection. It says nothing about the node the connection
+// was for, so the node is not taken down because of it.
+type ProviderError struct {
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("Unable to get the credentials or TLS configuration of a new connection: %v", e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError is returned when the transaction of an operation, or the Tracer wrapping the client
+// it uses, panicked. The operation is not retried and the node is not blamed for it.
+type PanicError struct {
+	Value interface{} // value passed to panic
+	Stack []byte      // stack trace of the goroutine that panicked
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprint("Panic while running a Cassandra transaction: ", e.Value)
+}
+
+// Unwrap returns the value passed to panic if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// attemptError returns the error to keep for a failed attempt on the node, timeouts are wrapped
+// in a TimeoutError
+func attemptError(node string, err error) error {
+	switch e := err.(type) {
+	case *cassandra.TimedOutException:
+		return &TimeoutError{Node: node, Err: err}
+	case thrift.TTransportException:
+		if e.TypeId() == thrift.TIMED_OUT {
+			return &TimeoutError{Node: node, Err: err}
+		}
+	}
+	if err == ErrorConnectionTimeout {
+		return &TimeoutError{Node: node, Err: err}
+	}
+	return err
+}
+
+// runTransaction runs the transaction with c, wrapping its client with the Tracer of the pool if
+// any, and turns a panic in any of them into a PanicError
+func (cp *connectionPool) runTransaction(t transaction, c *connection) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	if cp.tracer != nil {
+		c = &connection{
+			node:        c.node,
+			client:      cp.tracer(cp, c.client),
+			transport:   c.transport,
+			consistency: c.consistency,
+			spec:        c.spec,
+		}
+	}
+	return t(c)
+}
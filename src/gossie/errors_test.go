@@ -0,0 +1,133 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+func newErrorsTestPool(connections int) *connectionPool {
+	n := newNode(localEndpoint, 0)
+	for i := 0; i < connections; i++ {
+		n.available.Push(newTestConnection(n))
+	}
+	return &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+}
+
+func TestRetriesExhaustedError(t *testing.T) {
+	cp := newErrorsTestPool(1)
+	unavailable := cassandra.NewUnavailableException()
+	err := cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		return unavailable
+	})
+
+	var exhausted *RetriesExhaustedError
+	assert.True(t, errors.As(err, &exhausted))
+	assert.Equal(t, []Attempt{{localEndpoint, unavailable}, {localEndpoint, unavailable}}, exhausted.Attempts)
+	var cause *cassandra.UnavailableException
+	assert.True(t, errors.As(err, &cause))
+	assert.True(t, errors.Is(err, unavailable))
+}
+
+func TestNoHostAvailableError(t *testing.T) {
+	cp := newErrorsTestPool(1)
+	timedOut := cassandra.NewTimedOutException()
+	err := cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		return timedOut
+	})
+
+	// the node is blacklisted after timing out, so there is no node left for the second attempt
+	var noHost *NoHostAvailableError
+	assert.True(t, errors.As(err, &noHost))
+	assert.Equal(t, 1, len(noHost.Attempts))
+	var timeout *TimeoutError
+	assert.True(t, errors.As(err, &timeout))
+	assert.Equal(t, localEndpoint, timeout.Node)
+	assert.True(t, errors.Is(err, timedOut))
+	assert.Equal(t, "All nodes are marked down, cannot acquire new connection", (&NoHostAvailableError{}).Error())
+}
+
+func TestPanicError(t *testing.T) {
+	cp := newErrorsTestPool(1)
+	cp.options.Logger = &testLogger{}
+	attempts := 0
+	err := cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		attempts++
+		panic("boom")
+	})
+	var p *PanicError
+	assert.True(t, errors.As(err, &p))
+	assert.Equal(t, "boom", p.Value)
+	assert.NotEmpty(t, p.Stack)
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, 0, cp.cluster.nodes[0].available.Len())
+	assert.Equal(t, NodeUp, cp.cluster.nodes[0].getState())
+	// a panic is not a failure of the node
+	assert.Equal(t, uint64(0), cp.cluster.nodes[0].stats.failures)
+	assert.Equal(t, err, p)
+
+	cp = newErrorsTestPool(1)
+	cp.options.Logger = &testLogger{}
+	broken := errors.New("broken tracer")
+	cp.tracer = func(ConnectionPool, cassandra.Cassandra) cassandra.Cassandra { panic(broken) }
+	err = cp.runOperation(context.Background(), &operation{}, func(c *connection) error { return nil })
+	assert.True(t, errors.Is(err, broken))
+}
+
+func TestInvalidRequestError(t *testing.T) {
+	cp := newErrorsTestPool(1)
+	cp.options.Logger = &testLogger{}
+	invalid := cassandra.NewInvalidRequestException()
+	err := cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		return invalid
+	})
+
+	// the request is not retried and its error is returned as it is
+	assert.Equal(t, invalid, err)
+}
+
+func TestAcquireErrors(t *testing.T) {
+	cp := newErrorsTestPool(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cp.cluster.nodes[0].slots = make(chan struct{})
+	_, err := cp.acquireFrom(ctx, cp.newQueryPlan(nil, 0))
+	assert.Equal(t, context.Canceled, err)
+
+	cp.cluster.nodes[0].slots = nil
+	cp.cluster.nodes[0].node = "invalid address"
+	_, err = cp.acquireFrom(context.Background(), cp.newQueryPlan(nil, 0))
+	var noHost *NoHostAvailableError
+	assert.True(t, errors.As(err, &noHost))
+	assert.Equal(t, "invalid address", noHost.Attempts[0].Node)
+}
+
+type panicTracer struct{}
+
+func (panicTracer) Start(op OperationInfo) OperationSpan { panic("tracer") }
+
+func TestOperationTracerPanic(t *testing.T) {
+	cp := newErrorsTestPool(1)
+	logger := &testLogger{}
+	cp.options.Logger = logger
+	cp.options.OperationTracer = panicTracer{}
+	ctx, end := cp.startSpan(context.Background(), OperationInfo{Name: "Reader.Get"})
+	err := cp.runOperation(ctx, &operation{}, func(c *connection) error { return nil })
+	end(err)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"ERROR OperationTracer panicked operation=Reader.Get panic=tracer",
+		"ERROR OperationTracer panicked operation= panic=tracer",
+	}, logger.messages)
+}
+
+func TestAttemptError(t *testing.T) {
+	err := attemptError("n", ErrorConnectionTimeout)
+	assert.Equal(t, &TimeoutError{Node: "n", Err: ErrorConnectionTimeout}, err)
+	assert.True(t, errors.Is(err, ErrorConnectionTimeout))
+	other := errors.New("refused")
+	assert.Equal(t, other, attemptError("n", other))
+}
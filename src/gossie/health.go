@@ -0,0 +1,253 @@
+package gossie
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+// NodeState is the state of the circuit breaker of a node
+type NodeState int
+
+const (
+	// NodeUp means the circuit is closed, the node is used normally
+	NodeUp NodeState = iota
+
+	// NodeDown means the circuit is open, the node is not used until it recovers
+	NodeDown
+
+	// NodeRecovering means the circuit is half-open, the node is used again for a single trial
+	// transaction whose result decides if it goes back up or down
+	NodeRecovering
+)
+
+func (s NodeState) String() string {
+	switch s {
+	case NodeUp:
+		return "up"
+	case NodeDown:
+		return "down"
+	case NodeRecovering:
+		return "recovering"
+	}
+	return "unknown"
+}
+
+// NodeEvent describes a change in the state of a node, see PoolOptions.OnNodeStateChange
+type NodeEvent struct {
+	Addr string    // "host:port" address of the node
+	From NodeState // previous state
+	To   NodeState // new state
+	Err  error     // error that took the node down, if any
+}
+
+// breaker is the circuit breaker of a node
+type breaker struct {
+	state       NodeState
+	lastFailure int       // unix time the node went down
+	markedDown  bool      // taken down by MarkDown, it does not recover until MarkUp
+	trial       bool      // a transaction was sent to the recovering node and did not finish yet
+	windowStart time.Time // start of the current error rate period
+	requests    int       // transactions in the current period
+	failures    int       // failed transactions in the current period
+	m           sync.Mutex
+}
+
+// setState changes the state of the breaker, returning the previous state
+func (b *breaker) setState(to NodeState) NodeState {
+	from := b.state
+	b.state = to
+	b.requests, b.failures = 0, 0
+	b.trial = false
+	if to == NodeDown {
+		b.lastFailure = int(nowfunc().Unix())
+	}
+	return from
+}
+
+func (n *node) getState() NodeState {
+	n.m.Lock()
+	defer n.m.Unlock()
+	return n.state
+}
+
+// blacklist takes the node down because of err
+func (cp *connectionPool) blacklist(n *node, err error) {
+	cp.options.Logger.Debug("Closing connections to blacklisted node", "node", n.node, "connections", n.available.Len())
+	if from := n.blacklist(); from != NodeDown {
+		cp.notify(n, from, NodeDown, err)
+	}
+}
+
+func (cp *connectionPool) notify(n *node, from, to NodeState, err error) {
+	cp.options.Logger.Info("Node state changed", "node", n.node, "from", from, "to", to)
+	if to == NodeDown {
+		atomic.AddUint64(&n.stats.blacklisted, 1)
+		cp.options.MetricsCollector.NodeBlacklisted(n.node, err)
+	}
+	if cp.options.OnNodeStateChange != nil {
+		cp.options.OnNodeStateChange(NodeEvent{Addr: n.node, From: from, To: to, Err: err})
+	}
+}
+
+// isUp reports if the node can be used, without changing its state. A recovering node can be
+// used while no trial transaction is running on it. Without health checks a node that is down
+// can be used again after Grace seconds.
+func (cp *connectionPool) isUp(n *node, now int) bool {
+	n.m.Lock()
+	defer n.m.Unlock()
+	switch n.state {
+	case NodeUp:
+		return true
+	case NodeRecovering:
+		return !n.trial
+	}
+	return cp.canRecover(n, now)
+}
+
+// canRecover reports if the node, which is down, can start recovering. Must be called with the
+// node locked.
+func (cp *connectionPool) canRecover(n *node, now int) bool {
+	return !n.markedDown && cp.options.HealthCheckInterval <= 0 && n.lastFailure+cp.options.Grace < now
+}
+
+// pick reports if the node can be used for a transaction like isUp, and if it is recovering
+// makes that transaction its trial. A node that is down starts recovering when it is picked.
+func (cp *connectionPool) pick(n *node, now int) bool {
+	n.m.Lock()
+	switch n.state {
+	case NodeUp:
+		n.m.Unlock()
+		return true
+	case NodeRecovering:
+		picked := !n.trial
+		n.trial = true
+		n.m.Unlock()
+		return picked
+	}
+	if !cp.canRecover(n, now) {
+		n.m.Unlock()
+		return false
+	}
+	from := n.setState(NodeRecovering)
+	n.trial = true
+	n.m.Unlock()
+	cp.notify(n, from, NodeRecovering, nil)
+	return true
+}
+
+// endTrial lets another transaction try the recovering node when its trial ended without a
+// result, for example because no connection could be acquired or the operation was cancelled
+func (n *node) endTrial() {
+	n.m.Lock()
+	n.trial = false
+	n.m.Unlock()
+}
+
+// report updates the circuit breaker of the node with the result of a transaction
+func (cp *connectionPool) report(n *node, err error, latency time.Duration) {
+	failed := isNodeFailure(err) ||
+		(cp.options.LatencyThreshold > 0 && latency > cp.options.LatencyThreshold)
+
+	n.m.Lock()
+	from, to := n.state, n.state
+	switch n.state {
+	case NodeRecovering:
+		if failed {
+			to = NodeDown
+		} else {
+			to = NodeUp
+		}
+	case NodeUp:
+		if cp.options.ErrorRateThreshold <= 0 {
+			break
+		}
+		now := nowfunc()
+		if now.Sub(n.windowStart) >= cp.options.BreakerWindow {
+			n.windowStart, n.requests, n.failures = now, 0, 0
+		}
+		n.requests++
+		if failed {
+			n.failures++
+		}
+		if n.requests >= cp.options.BreakerMinRequests &&
+			float64(n.failures) >= cp.options.ErrorRateThreshold*float64(n.requests) {
+			to = NodeDown
+		}
+	}
+	if to != from {
+		n.setState(to)
+	}
+	n.m.Unlock()
+
+	if to != from {
+		if to == NodeDown {
+			n.closeIdle()
+		}
+		cp.notify(n, from, to, err)
+	}
+}
+
+// isNodeFailure reports if the error of a transaction means the node is not working properly
+func isNodeFailure(err error) bool {
+	switch err.(type) {
+	case nil, *cassandra.InvalidRequestException, *cassandra.UnavailableException, *cassandra.NotFoundException, *PanicError:
+		return false
+	}
+	return true
+}
+
+// healthChecker probes the nodes that are down every d, a node that answers starts recovering
+func (cp *connectionPool) healthChecker(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-ticker.C:
+		}
+		nodes, _ := cp.cluster.get()
+		var wg sync.WaitGroup
+		for _, n := range nodes {
+			if n.getState() != NodeDown || n.isRemoved() {
+				continue
+			}
+			wg.Add(1)
+			go func(n *node) {
+				defer wg.Done()
+				cp.probe(n)
+			}(n)
+		}
+		wg.Wait()
+	}
+}
+
+// probe opens a new connection to a node that is down, which checks its Thrift API version with
+// DescribeVersion, and marks the node as recovering if it succeeds
+func (cp *connectionPool) probe(n *node) {
+	c, err := cp.dial(n, cp.options.Timeout)
+	if _, ok := err.(*ProviderError); ok {
+		// the node could not be checked, it is probed again next time
+		cp.options.Logger.Error("Unable to probe node", "node", n.node, "error", err)
+		return
+	}
+	if err != nil {
+		cp.options.Logger.Debug("Node is still down", "node", n.node, "error", err)
+		return
+	}
+	c.close()
+
+	n.m.Lock()
+	from := n.state
+	recovered := from == NodeDown && !n.markedDown
+	if recovered {
+		n.setState(NodeRecovering)
+	}
+	n.m.Unlock()
+	if recovered {
+		cp.notify(n, from, NodeRecovering, nil)
+	}
+}
@@ -0,0 +1,94 @@
+package gossie
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+func TestNodeRecoversAfterGrace(t *testing.T) {
+	var events []NodeEvent
+	options := DefaultPoolOptions
+	options.OnNodeStateChange = func(e NodeEvent) { events = append(events, e) }
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+	now := int(nowfunc().Unix())
+
+	timeout := cassandra.NewTimedOutException()
+	cp.blacklist(n, timeout)
+	cp.blacklist(n, timeout)
+	assert.Equal(t, NodeDown, n.getState())
+	assert.False(t, cp.isUp(n, now))
+	assert.False(t, cp.pick(n, now))
+	// building a plan does not change the state of the node, picking it does
+	assert.True(t, cp.isUp(n, now+options.Grace+1))
+	assert.Equal(t, NodeDown, n.getState())
+	assert.True(t, cp.pick(n, now+options.Grace+1))
+	assert.Equal(t, NodeRecovering, n.getState())
+
+	// only the trial transaction is sent to the recovering node
+	assert.False(t, cp.isUp(n, now+options.Grace+1))
+	assert.False(t, cp.pick(n, now+options.Grace+1))
+	n.endTrial()
+	assert.True(t, cp.pick(n, now+options.Grace+1))
+
+	cp.report(n, errors.New("broken pipe"), 0)
+	assert.Equal(t, NodeDown, n.getState())
+	assert.True(t, cp.pick(n, now+options.Grace+1))
+	cp.report(n, nil, 0)
+	assert.Equal(t, NodeUp, n.getState())
+
+	assert.Equal(t, []NodeEvent{
+		{Addr: localEndpoint, From: NodeUp, To: NodeDown, Err: timeout},
+		{Addr: localEndpoint, From: NodeDown, To: NodeRecovering},
+		{Addr: localEndpoint, From: NodeRecovering, To: NodeDown, Err: errors.New("broken pipe")},
+		{Addr: localEndpoint, From: NodeDown, To: NodeRecovering},
+		{Addr: localEndpoint, From: NodeRecovering, To: NodeUp},
+	}, events)
+}
+
+func TestNodeStaysDownWithHealthChecks(t *testing.T) {
+	options := DefaultPoolOptions
+	options.HealthCheckInterval = time.Second
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	n.blacklist()
+	assert.False(t, cp.pick(n, int(nowfunc().Unix())+options.Grace+1))
+
+	// nothing listens on port 1
+	cp.keyspace = keyspace
+	cp.options.Timeout = 100 * time.Millisecond
+	n.node = "127.0.0.1:1"
+	cp.probe(n)
+	assert.Equal(t, NodeDown, n.getState())
+}
+
+func TestErrorRateThreshold(t *testing.T) {
+	options := DefaultPoolOptions
+	options.ErrorRateThreshold = 0.5
+	options.BreakerMinRequests = 4
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	cp.report(n, errors.New("broken pipe"), 0)
+	cp.report(n, errors.New("broken pipe"), 0)
+	cp.report(n, cassandra.NewUnavailableException(), 0)
+	assert.Equal(t, NodeUp, n.getState())
+	cp.report(n, cassandra.NewInvalidRequestException(), 0)
+	assert.Equal(t, NodeDown, n.getState())
+
+	n = newNode(localEndpoint, 0)
+	cp.options.LatencyThreshold = 10 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		cp.report(n, nil, time.Millisecond)
+	}
+	cp.report(n, nil, time.Second)
+	cp.report(n, nil, time.Second)
+	assert.Equal(t, NodeUp, n.getState())
+	cp.report(n, nil, time.Second)
+	assert.Equal(t, NodeDown, n.getState())
+}
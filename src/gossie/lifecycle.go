@@ -0,0 +1,101 @@
+package gossie
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expired returns true if the connection reached PoolOptions.MaxConnectionAge or
+// MaxConnectionUses, or was recycled, and must be closed instead of being used again
+func (cp *connectionPool) expired(c *connection, now time.Time) bool {
+	if c.generation != atomic.LoadInt32(&cp.cluster.generation) {
+		return true
+	}
+	if cp.options.MaxConnectionAge > 0 && now.Sub(c.created) >= cp.options.MaxConnectionAge {
+		return true
+	}
+	return cp.options.MaxConnectionUses > 0 && c.uses >= cp.options.MaxConnectionUses
+}
+
+// warmUp opens new connections to the nodes that are up until they have PoolOptions.MinIdle
+// idle ones
+func (cp *connectionPool) warmUp() {
+	if cp.options.MinIdle <= 0 {
+		return
+	}
+	nodes, _ := cp.cluster.get()
+	for _, n := range nodes {
+		if !n.isRemoved() && n.getState() == NodeUp {
+			cp.warmUpNode(n)
+		}
+	}
+}
+
+// warmUpNode opens connections to the node until it has PoolOptions.MinIdle idle ones, stopping
+// at the first error or when it reaches MaxConnections
+func (cp *connectionPool) warmUpNode(n *node) {
+	for n.available.Len() < cp.options.MinIdle {
+		if n.slots != nil {
+			select {
+			case n.slots <- struct{}{}:
+			default:
+				return
+			}
+		}
+		c, err := cp.dial(n, cp.options.Timeout)
+		if err != nil {
+			n.freeSlot()
+			cp.options.Logger.Warn("Unable to open idle connection", "node", n.node, "error", err)
+			cp.report(n, err, 0)
+			return
+		}
+		c.slot = n.slots != nil
+		n.available.Push(c)
+		n.signalRelease()
+	}
+}
+
+// expireIdle closes the idle connections that expired
+func (cp *connectionPool) expireIdle(now time.Time) {
+	nodes, _ := cp.cluster.get()
+	for _, n := range nodes {
+		for _, c := range n.available.RemoveIf(func(c *connection) bool { return cp.expired(c, now) }) {
+			cp.options.Logger.Debug("Closing expired connection", "node", n.node, "age", now.Sub(c.created))
+			c.close()
+		}
+	}
+}
+
+// keepalive checks every d the connections that have been idle for longer than d, so the ones
+// dropped by load balancers or firewalls are closed before a transaction tries to use them
+func (cp *connectionPool) keepalive(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-ticker.C:
+		}
+		nodes, _ := cp.cluster.get()
+		for _, n := range nodes {
+			cp.probeIdle(n, nowfunc().Add(-d))
+		}
+	}
+}
+
+// probeIdle sends a describe_version to the idle connections of the node last used before
+// since, closing the ones that fail. The others are put back without counting the probe as a
+// use, and the ones that expired or whose node left the pool meanwhile are closed.
+func (cp *connectionPool) probeIdle(n *node, since time.Time) {
+	stale := n.available.RemoveIf(func(c *connection) bool { return c.lastUsed.Before(since) })
+	for _, c := range stale {
+		if _, err := c.client.DescribeVersion(); err != nil {
+			cp.options.Logger.Debug("Closing dropped idle connection", "node", n.node, "error", err)
+			c.close()
+			continue
+		}
+		c.lastUsed = nowfunc()
+		cp.putBack(c, c.lastUsed)
+	}
+}
@@ -0,0 +1,136 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func TestMaxConnectionUses(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	c := newTestConnection(n)
+	n.available.Push(c)
+	options := DefaultPoolOptions
+	options.MaxConnectionUses = 2
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	for i := 1; i <= 2; i++ {
+		err := cp.runOperation(context.Background(), &operation{}, func(tc *connection) error {
+			assert.Equal(t, c, tc)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, i, c.uses)
+	}
+	// closed after its second transaction
+	assert.Equal(t, 0, n.available.Len())
+}
+
+func TestMaxConnectionAge(t *testing.T) {
+	now := time.Now()
+	n := newNode(localEndpoint, 0)
+	old := newTestConnection(n)
+	old.created = now.Add(-2 * time.Minute)
+	recent := newTestConnection(n)
+	recent.created = now
+	n.available.Push(old)
+	n.available.Push(recent)
+	options := DefaultPoolOptions
+	options.MaxConnectionAge = time.Minute
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	assert.True(t, cp.expired(old, now))
+	assert.False(t, cp.expired(recent, now))
+	cp.expireIdle(now)
+	assert.Equal(t, []*connection{recent}, n.available.l)
+}
+
+func TestProbeIdle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	now := time.Now()
+	n := newNode(localEndpoint, 0)
+	var conns []*connection
+	for i := 0; i < 3; i++ {
+		c := newTestConnection(n)
+		cli := mock_cassandra.NewMockCassandra(ctrl)
+		c.client = cli
+		c.lastUsed = now.Add(-time.Duration(i) * time.Minute)
+		conns = append(conns, c)
+		n.available.Push(c)
+	}
+	conns[1].client.(*mock_cassandra.MockCassandra).EXPECT().DescribeVersion().Return("19.36.0", nil)
+	conns[2].client.(*mock_cassandra.MockCassandra).EXPECT().DescribeVersion().Return("", errors.New("connection reset by peer"))
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	cp.probeIdle(n, now.Add(-30*time.Second))
+	assert.Equal(t, []*connection{conns[0], conns[1]}, n.available.l)
+	assert.True(t, conns[1].lastUsed.After(now))
+}
+
+func TestProbeIdleReleases(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	now := time.Now()
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+	probed := func() *connection {
+		c := newTestConnection(n)
+		cli := mock_cassandra.NewMockCassandra(ctrl)
+		cli.EXPECT().DescribeVersion().Return("19.36.0", nil)
+		c.client, c.created, c.lastUsed = cli, now.Add(-time.Hour), now.Add(-time.Minute)
+		n.available.Push(c)
+		return c
+	}
+
+	// the connection expired while idle
+	cp.options.MaxConnectionAge = time.Minute
+	probed()
+	cp.probeIdle(n, now.Add(-30*time.Second))
+	assert.Equal(t, 0, n.available.Len())
+
+	// the node left the pool while its connection was probed
+	cp.options.MaxConnectionAge = 0
+	probed()
+	atomic.StoreInt32(&n.removed, 1)
+	cp.probeIdle(n, now.Add(-30*time.Second))
+	assert.Equal(t, 0, n.available.Len())
+
+	// the probe is not a use of the connection nor a release to the metrics
+	atomic.StoreInt32(&n.removed, 0)
+	collector := NewPrometheusCollector()
+	cp.options.MetricsCollector = collector
+	cp.options.MaxConnectionUses = 1
+	c := probed()
+	cp.probeIdle(n, now.Add(-30*time.Second))
+	assert.Equal(t, 1, n.available.Len())
+	assert.Equal(t, 0, c.uses)
+	assert.Empty(t, collector.nodes)
+}
+
+func TestWarmUpNode(t *testing.T) {
+	n := newNode(invalidEndpoint, 1)
+	options := DefaultPoolOptions
+	options.MinIdle = 2
+	options.Timeout = 10 * time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+	cp.options.Logger = &testLogger{}
+
+	// the slot taken for the failed connection is given back
+	cp.warmUpNode(n)
+	assert.Equal(t, 0, n.available.Len())
+	assert.Equal(t, 0, len(n.slots))
+
+	// nothing is opened when the node reached MaxConnections
+	n.slots <- struct{}{}
+	cp.warmUpNode(n)
+	assert.Equal(t, 1, len(n.slots))
+}
@@ -28,6 +28,32 @@ func (q *lifo) Pop() (*connection, bool) {
 	return value, true
 }
 
+func (q *lifo) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.l)
+}
+
+// RemoveIf removes the connections for which f returns true and returns them
+func (q *lifo) RemoveIf(f func(*connection) bool) []*connection {
+	q.m.Lock()
+	defer q.m.Unlock()
+	var removed []*connection
+	kept := q.l[:0]
+	for _, c := range q.l {
+		if f(c) {
+			removed = append(removed, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	for i := len(kept); i < len(q.l); i++ {
+		q.l[i] = nil
+	}
+	q.l = kept
+	return removed
+}
+
 //This function return the item from the bottom of the stack
 //if size is more than n
 //Should not be used very extensively because it creates garbage in memory
@@ -69,3 +69,18 @@ func TestKeepN(t *testing.T) {
 
 	assert.Equal(t, 2, len(l.l))
 }
+
+func TestRemoveIf(t *testing.T) {
+	var l lifo
+	conn := &connection{uses: 1}
+	conn2 := &connection{uses: 2}
+	conn3 := &connection{uses: 3}
+	l.Push(conn)
+	l.Push(conn2)
+	l.Push(conn3)
+
+	removed := l.RemoveIf(func(c *connection) bool { return c.uses != 2 })
+	assert.Equal(t, []*connection{conn, conn3}, removed)
+	assert.Equal(t, []*connection{conn2}, l.l)
+	assert.Empty(t, l.RemoveIf(func(c *connection) bool { return false }))
+}
@@ -0,0 +1,140 @@
+package gossie
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits throttles the operations run by a ConnectionPool so big jobs, like backfills, do not
+// overload the cluster. Operations wait until they are allowed to run, or until their context is
+// done. The zero value does not limit anything.
+type Limits struct {
+	OpsPerSecond       float64 // if not 0, start up to OpsPerSecond operations per second, every page of a RangeScan or replica of a MultiGet is one
+	MutationsPerSecond float64 // if not 0, write up to MutationsPerSecond mutations per second, every inserted column, counter delta or deletion is one
+	MaxInFlight        int     // if not 0, run up to MaxInFlight operations at the same time
+}
+
+// limiter enforces the Limits of a pool, a nil limiter does not limit anything
+type limiter struct {
+	ops       *rateLimiter
+	mutations *rateLimiter
+	inFlight  chan struct{}
+}
+
+func newLimiter(limits Limits) *limiter {
+	if limits == (Limits{}) {
+		return nil
+	}
+	l := &limiter{
+		ops:       newRateLimiter(limits.OpsPerSecond),
+		mutations: newRateLimiter(limits.MutationsPerSecond),
+	}
+	if limits.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limits.MaxInFlight)
+	}
+	return l
+}
+
+// wait blocks until the operation is allowed to run, it must be followed by a call to done
+// unless it returns an error
+func (l *limiter) wait(ctx context.Context, op *operation) error {
+	if l == nil {
+		return nil
+	}
+	if err := l.ops.wait(ctx, 1); err != nil {
+		return err
+	}
+	mutations := float64(op.mutations)
+	if mutations > 0 {
+		if err := l.mutations.wait(ctx, mutations); err != nil {
+			// the operation does not run, give back the tokens it took
+			l.ops.cancel(1)
+			return err
+		}
+	}
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			l.ops.cancel(1)
+			if mutations > 0 {
+				l.mutations.cancel(mutations)
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// done marks the end of an operation that was allowed to run by wait
+func (l *limiter) done() {
+	if l != nil && l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+// rateLimiter is a token bucket refilled with rate tokens per second and holding up to a second
+// of them. Requests for more tokens than available are allowed to go into debt, so requests
+// bigger than the bucket still run once the debt they would make is paid off.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	m      sync.Mutex
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: nowfunc()}
+}
+
+// reserve takes n tokens and returns how long to wait before using them
+func (r *rateLimiter) reserve(n float64, now time.Time) time.Duration {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if now.After(r.last) {
+		r.tokens += now.Sub(r.last).Seconds() * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+	}
+	r.tokens -= n
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// cancel gives back n tokens taken by a request that is not run, a nil rateLimiter has none
+func (r *rateLimiter) cancel(n float64) {
+	if r == nil {
+		return
+	}
+	r.m.Lock()
+	r.tokens += n
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.m.Unlock()
+}
+
+// wait blocks until n tokens are available or ctx is done, a nil rateLimiter never blocks
+func (r *rateLimiter) wait(ctx context.Context, n float64) error {
+	if r == nil {
+		return nil
+	}
+	if err := sleep(ctx, r.reserve(n, nowfunc())); err != nil {
+		r.cancel(n)
+		return err
+	}
+	return nil
+}
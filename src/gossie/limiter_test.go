@@ -0,0 +1,73 @@
+package gossie
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	r := &rateLimiter{rate: 10, burst: 10, tokens: 10, last: now}
+	assert.Equal(t, time.Duration(0), r.reserve(10, now))
+	assert.Equal(t, 100*time.Millisecond, r.reserve(1, now))
+	// requests bigger than the bucket go into debt
+	assert.Equal(t, 2100*time.Millisecond, r.reserve(20, now))
+	r.cancel(21)
+	assert.Equal(t, time.Duration(0), r.reserve(5, now.Add(500*time.Millisecond)))
+	// the bucket never holds more than a second of tokens
+	assert.Equal(t, 500*time.Millisecond, r.reserve(15, now.Add(time.Hour)))
+}
+
+func TestLimiter(t *testing.T) {
+	assert.Nil(t, newLimiter(Limits{}))
+	var l *limiter
+	assert.NoError(t, l.wait(context.Background(), &operation{}))
+	l.done()
+
+	l = newLimiter(Limits{MutationsPerSecond: 1, MaxInFlight: 1})
+	assert.Nil(t, l.ops)
+	assert.NoError(t, l.wait(context.Background(), &operation{mutations: 1}))
+
+	// both the in flight and the mutation limits are reached
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, l.wait(ctx, &operation{}))
+	assert.Equal(t, context.DeadlineExceeded, l.wait(ctx, &operation{mutations: 1}))
+	l.done()
+	assert.NoError(t, l.wait(context.Background(), &operation{}))
+}
+
+func TestLimiterRefund(t *testing.T) {
+	l := newLimiter(Limits{OpsPerSecond: 10, MutationsPerSecond: 10, MaxInFlight: 1})
+	assert.NoError(t, l.wait(context.Background(), &operation{}))
+
+	// the operation waiting for the in flight limit gives back its tokens when cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, l.wait(ctx, &operation{mutations: 5}))
+	assert.InDelta(t, 9, l.ops.tokens, 0.1)
+	assert.Equal(t, 10.0, l.mutations.tokens)
+}
+
+func TestWithLimits(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	n.available.Push(newTestConnection(n))
+	n.available.Push(newTestConnection(n))
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	background := cp.WithLimits(Limits{MaxInFlight: 1}).WithTracer(nil).(*connectionPool)
+	assert.Equal(t, 1, cap(background.limiter.inFlight))
+	assert.Nil(t, cp.limiter)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := background.runOperation(ctx, &operation{}, func(c *connection) error {
+		// the limit is per pool, the parent pool is not throttled
+		assert.NoError(t, cp.runOperation(ctx, &operation{}, func(c *connection) error { return nil }))
+		cancel()
+		return background.runOperation(ctx, &operation{}, func(c *connection) error { return nil })
+	})
+	assert.Equal(t, context.Canceled, err)
+}
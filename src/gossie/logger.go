@@ -0,0 +1,64 @@
+package gossie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// Logger receives the log messages of a ConnectionPool and of the readers and writers created
+// from it. fields are alternating keys and values that give context to the message, like the
+// node or the error it refers to.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// NewGlogLogger returns a Logger that writes to glog, with the fields appended to the message
+// as key=value pairs. Debug messages are only written at verbosity level 1 or higher.
+func NewGlogLogger() Logger {
+	return glogLogger{}
+}
+
+type glogLogger struct{}
+
+func (glogLogger) Debug(msg string, fields ...interface{}) {
+	if glog.V(1) {
+		glog.InfoDepth(1, formatFields(msg, fields))
+	}
+}
+
+func (glogLogger) Info(msg string, fields ...interface{}) {
+	glog.InfoDepth(1, formatFields(msg, fields))
+}
+
+func (glogLogger) Warn(msg string, fields ...interface{}) {
+	glog.WarningDepth(1, formatFields(msg, fields))
+}
+
+func (glogLogger) Error(msg string, fields ...interface{}) {
+	glog.ErrorDepth(1, formatFields(msg, fields))
+}
+
+// formatFields appends the fields to msg as key=value pairs, byte slices are quoted
+func formatFields(msg string, fields []interface{}) string {
+	var b bytes.Buffer
+	b.WriteString(msg)
+	for i := 0; i < len(fields); i += 2 {
+		fmt.Fprintf(&b, " %v=", fields[i])
+		if i+1 == len(fields) {
+			b.WriteString("MISSING")
+			break
+		}
+		switch v := fields[i+1].(type) {
+		case []byte:
+			fmt.Fprintf(&b, "%q", v)
+		default:
+			fmt.Fprintf(&b, "%v", v)
+		}
+	}
+	return b.String()
+}
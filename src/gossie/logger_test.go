@@ -0,0 +1,46 @@
+package gossie
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogger struct {
+	messages []string
+	m        sync.Mutex
+}
+
+func (l *testLogger) Debug(msg string, fields ...interface{}) { l.log("DEBUG", msg, fields) }
+func (l *testLogger) Info(msg string, fields ...interface{})  { l.log("INFO", msg, fields) }
+func (l *testLogger) Warn(msg string, fields ...interface{})  { l.log("WARN", msg, fields) }
+func (l *testLogger) Error(msg string, fields ...interface{}) { l.log("ERROR", msg, fields) }
+
+func (l *testLogger) log(level, msg string, fields []interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.messages = append(l.messages, level+" "+formatFields(msg, fields))
+}
+
+func TestFormatFields(t *testing.T) {
+	assert.Equal(t, "msg", formatFields("msg", nil))
+	assert.Equal(t, "msg node=a:9160 size=3", formatFields("msg", []interface{}{"node", "a:9160", "size", 3}))
+	assert.Equal(t, `msg key="k\x00"`, formatFields("msg", []interface{}{"key", []byte("k\x00")}))
+	assert.Equal(t, "msg error=MISSING", formatFields("msg", []interface{}{"error"}))
+}
+
+func TestPoolLogger(t *testing.T) {
+	logger := &testLogger{}
+	options := DefaultPoolOptions
+	options.Logger = logger
+	n := newNode(localEndpoint, 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	cp.blacklist(n, errors.New("timed out"))
+	assert.Equal(t, []string{
+		"DEBUG Closing connections to blacklisted node node=" + localEndpoint + " connections=0",
+		"INFO Node state changed node=" + localEndpoint + " from=up to=down",
+	}, logger.messages)
+}
@@ -0,0 +1,113 @@
+package gossie
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsCollector receives the events of a ConnectionPool. Its methods are called concurrently
+// from the goroutines running transactions and must not block.
+type MetricsCollector interface {
+	// ConnectionAcquired is called when a transaction gets a connection to node after waiting
+	// for wait, including the time to open a new connection when needed
+	ConnectionAcquired(node string, wait time.Duration)
+
+	// ConnectionReleased is called when a connection is returned idle to the pool
+	ConnectionReleased(node string)
+
+	// ConnectionExpired is called when a connection is closed instead of being returned to the
+	// pool, because it reached MaxConnectionAge or MaxConnectionUses or it was recycled
+	ConnectionExpired(node string)
+
+	// ConnectionBled is called when the bleeder closes an idle connection of node
+	ConnectionBled(node string)
+
+	// NodeBlacklisted is called when node goes down because of err
+	NodeBlacklisted(node string, err error)
+
+	// Retried is called when a failed attempt on node is going to be retried
+	Retried(node string, err error)
+
+	// OperationDone is called when a transaction finishes, after all its attempts
+	OperationDone(latency time.Duration, err error)
+}
+
+type nopCollector struct{}
+
+func (nopCollector) ConnectionAcquired(node string, wait time.Duration) {}
+func (nopCollector) ConnectionReleased(node string)                     {}
+func (nopCollector) ConnectionExpired(node string)                      {}
+func (nopCollector) ConnectionBled(node string)                         {}
+func (nopCollector) NodeBlacklisted(node string, err error)             {}
+func (nopCollector) Retried(node string, err error)                     {}
+func (nopCollector) OperationDone(latency time.Duration, err error)     {}
+
+// PoolStats is a snapshot of the state of a ConnectionPool, see ConnectionPool.Stats
+type PoolStats struct {
+	Running    int         // transactions in progress
+	Operations uint64      // finished transactions
+	Errors     uint64      // transactions that returned an error
+	Retries    uint64      // failed attempts that were retried
+	Nodes      []NodeStats // sorted by address
+}
+
+// NodeStats is the state of a node of a ConnectionPool
+type NodeStats struct {
+	Addr        string
+	State       NodeState
+	Open        int    // open connections
+	Idle        int    // open connections ready to be used
+	InUse       int    // open connections running a transaction
+	Acquired    uint64 // connections handed to transactions
+	Failures    uint64 // failed attempts
+	Blacklisted uint64 // times the node went down
+}
+
+// counters are the statistics of a pool or node, updated atomically
+type counters struct {
+	operations  uint64
+	errors      uint64
+	retries     uint64
+	acquired    uint64
+	failures    uint64
+	blacklisted uint64
+}
+
+func (cp *connectionPool) Stats() PoolStats {
+	cp.cluster.lm.Lock()
+	running := cp.cluster.running
+	cp.cluster.lm.Unlock()
+
+	s := PoolStats{
+		Running:    running,
+		Operations: atomic.LoadUint64(&cp.cluster.stats.operations),
+		Errors:     atomic.LoadUint64(&cp.cluster.stats.errors),
+		Retries:    atomic.LoadUint64(&cp.cluster.stats.retries),
+	}
+	nodes, _ := cp.cluster.get()
+	for _, n := range nodes {
+		ns := NodeStats{
+			Addr:        n.node,
+			State:       n.getState(),
+			Open:        int(atomic.LoadInt32(&n.open)),
+			Idle:        n.available.Len(),
+			Acquired:    atomic.LoadUint64(&n.stats.acquired),
+			Failures:    atomic.LoadUint64(&n.stats.failures),
+			Blacklisted: atomic.LoadUint64(&n.stats.blacklisted),
+		}
+		// the counts are not read at the same time
+		if ns.InUse = ns.Open - ns.Idle; ns.InUse < 0 {
+			ns.InUse = 0
+		}
+		s.Nodes = append(s.Nodes, ns)
+	}
+	sort.Sort(byAddr(s.Nodes))
+	return s
+}
+
+type byAddr []NodeStats
+
+func (b byAddr) Len() int           { return len(b) }
+func (b byAddr) Less(i, j int) bool { return b[i].Addr < b[j].Addr }
+func (b byAddr) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
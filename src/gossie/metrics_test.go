@@ -0,0 +1,84 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	n1 := newNode("10.0.0.2:9160", 0)
+	n2 := newNode("10.0.0.1:9160", 0)
+	for i := 0; i < 2; i++ {
+		n1.available.Push(&connection{node: n1, transport: thrift.NewTFramedTransport(thrift.NewTMemoryBuffer())})
+	}
+	n1.open = 3
+	collector := NewPrometheusCollector()
+	options := DefaultPoolOptions
+	options.MetricsCollector = collector
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+	cp.blacklist(n2, errors.New("timed out"))
+
+	attempts := 0
+	err := cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("broken pipe")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	s := cp.Stats()
+	assert.Equal(t, uint64(1), s.Operations)
+	assert.Equal(t, uint64(0), s.Errors)
+	assert.Equal(t, uint64(1), s.Retries)
+	assert.Equal(t, 2, len(s.Nodes))
+	assert.Equal(t, "10.0.0.1:9160", s.Nodes[0].Addr)
+	assert.Equal(t, NodeDown, s.Nodes[0].State)
+	assert.Equal(t, uint64(1), s.Nodes[0].Blacklisted)
+	assert.Equal(t, NodeStats{Addr: "10.0.0.2:9160", State: NodeUp, Open: 3, Idle: 1, InUse: 2}, withoutCounters(s.Nodes[1]))
+
+	rec := httptest.NewRecorder()
+	collector.Handler(cp).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "# TYPE gossie_retries_total counter\n"))
+	assert.True(t, strings.Contains(body, "gossie_node_blacklisted_total{node=\"10.0.0.1:9160\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gossie_operation_duration_seconds_count 1\n"))
+	assert.True(t, strings.Contains(body, "gossie_operation_duration_seconds_bucket{le=\"+Inf\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gossie_connections_in_use{node=\"10.0.0.2:9160\"} 2\n"))
+	assert.True(t, strings.Contains(body, "gossie_node_state{node=\"10.0.0.1:9160\",state=\"down\"} 1\n"))
+}
+
+func withoutCounters(s NodeStats) NodeStats {
+	s.Acquired, s.Failures, s.Blacklisted = 0, 0, 0
+	return s
+}
+
+func TestReleaseMetrics(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	collector := NewPrometheusCollector()
+	options := DefaultPoolOptions
+	options.MetricsCollector = collector
+	options.MaxConnectionUses = 2
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	// the connection is only counted as released while it goes back to the pool
+	c := newTestConnection(n)
+	cp.release(c)
+	assert.Equal(t, 1, n.available.Len())
+	c, _ = n.available.Pop()
+	cp.release(c)
+	assert.Equal(t, 0, n.available.Len())
+
+	rec := httptest.NewRecorder()
+	collector.Handler(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	assert.True(t, strings.Contains(body, "gossie_connections_released_total{node=\"localhost:9160\"} 1\n"))
+	assert.True(t, strings.Contains(body, "gossie_connections_expired_total{node=\"localhost:9160\"} 1\n"))
+}
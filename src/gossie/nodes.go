@@ -0,0 +1,197 @@
+package gossie
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNodeNotFound = errors.New("Node not found in the pool")
+	ErrNodeDraining = errors.New("Timeout while draining the node, its connections in use are closed once released")
+)
+
+// drainPoll is how often RemoveNode checks if the connections of the node were closed
+const drainPoll = 10 * time.Millisecond
+
+// NodeInfo is the current state of a node of a ConnectionPool, see ConnectionPool.Nodes
+type NodeInfo struct {
+	Addr        string    // "host:port" address of the node
+	Name        string    // host name the address was resolved from, if any
+	State       NodeState // NodeDown while the node is blacklisted
+	MarkedDown  bool      // taken down with MarkDown, it stays down until MarkUp is called
+	LastFailure time.Time // last time the node went down, zero if it never did
+	Idle        int       // idle connections
+	Outstanding int       // transactions running on the node
+}
+
+type nodeInfos []NodeInfo
+
+func (n nodeInfos) Len() int           { return len(n) }
+func (n nodeInfos) Less(i, j int) bool { return n[i].Addr < n[j].Addr }
+func (n nodeInfos) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
+// add adds a node to the cluster, returning false if there is already a node with its address
+func (c *cluster) add(n *node) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, current := range c.nodes {
+		if current.node == n.node {
+			return false
+		}
+	}
+	nodes := make([]*node, len(c.nodes), len(c.nodes)+1)
+	copy(nodes, c.nodes)
+	c.nodes = append(nodes, n)
+	return true
+}
+
+// addSeed adds a "host:port" to the seeds resolved again by the resolver, if it is not one of them
+func (c *cluster) addSeed(seed string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, s := range c.seeds {
+		if s == seed {
+			return
+		}
+	}
+	seeds := make([]string, len(c.seeds), len(c.seeds)+1)
+	copy(seeds, c.seeds)
+	c.seeds = append(seeds, seed)
+}
+
+// getSeeds returns a snapshot of the seeds
+func (c *cluster) getSeeds() []string {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.seeds
+}
+
+// remove removes the node with the given address from the cluster and returns it, or nil if
+// there is no such node
+func (c *cluster) remove(addr string) *node {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for i, n := range c.nodes {
+		if n.node == addr {
+			nodes := make([]*node, 0, len(c.nodes)-1)
+			nodes = append(nodes, c.nodes[:i]...)
+			c.nodes = append(nodes, c.nodes[i+1:]...)
+			delete(c.datacenters, n)
+			return n
+		}
+	}
+	return nil
+}
+
+// find returns the node with the given address, or nil if there is no such node
+func (c *cluster) find(addr string) *node {
+	nodes, _ := c.get()
+	for _, n := range nodes {
+		if n.node == addr {
+			return n
+		}
+	}
+	return nil
+}
+
+func (cp *connectionPool) AddNode(addr string) {
+	addrs, name, err := resolveSeed(addr)
+	if err != nil {
+		cp.options.Logger.Warn("Cannot resolve node", "node", addr, "error", err)
+		addrs = []string{addr}
+	}
+	if name != "" {
+		cp.cluster.addSeed(addr)
+	}
+	for _, a := range addrs {
+		n := newNode(a, cp.options.MaxConnections)
+		n.name = name
+		if cp.cluster.add(n) {
+			cp.options.Logger.Info("Node added", "node", a, "host", name)
+			if cp.options.MinIdle > 0 {
+				cp.warmUpNode(n)
+			}
+		}
+	}
+}
+
+func (cp *connectionPool) RemoveNode(addr string) error {
+	n := cp.cluster.remove(addr)
+	if n == nil {
+		return ErrNodeNotFound
+	}
+	cp.options.Logger.Info("Node removed, draining it", "node", addr)
+	n.remove()
+	if !n.drain(cp.options.CloseTimeout) {
+		return ErrNodeDraining
+	}
+	return nil
+}
+
+// drain waits up to timeout for the connections of a removed node to be closed, returning false
+// if some of them are still open
+func (n *node) drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&n.open) > 0 {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		select {
+		case <-n.released:
+		case <-time.After(drainPoll):
+		}
+	}
+	return true
+}
+
+func (cp *connectionPool) Nodes() []NodeInfo {
+	nodes, _ := cp.cluster.get()
+	infos := make([]NodeInfo, 0, len(nodes))
+	for _, n := range nodes {
+		n.m.Lock()
+		info := NodeInfo{
+			Addr:        n.node,
+			Name:        n.name,
+			State:       n.state,
+			MarkedDown:  n.markedDown,
+			Idle:        n.available.Len(),
+			Outstanding: int(atomic.LoadInt32(&n.outstanding)),
+		}
+		if n.lastFailure != 0 {
+			info.LastFailure = time.Unix(int64(n.lastFailure), 0)
+		}
+		n.m.Unlock()
+		infos = append(infos, info)
+	}
+	sort.Sort(nodeInfos(infos))
+	return infos
+}
+
+func (cp *connectionPool) MarkDown(addr string) error {
+	n := cp.cluster.find(addr)
+	if n == nil {
+		return ErrNodeNotFound
+	}
+	n.m.Lock()
+	n.markedDown = true
+	n.m.Unlock()
+	cp.blacklist(n, nil)
+	return nil
+}
+
+func (cp *connectionPool) MarkUp(addr string) error {
+	n := cp.cluster.find(addr)
+	if n == nil {
+		return ErrNodeNotFound
+	}
+	n.m.Lock()
+	n.markedDown = false
+	from := n.setState(NodeUp)
+	n.m.Unlock()
+	if from != NodeUp {
+		cp.notify(n, from, NodeUp, nil)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package gossie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRemoveNode(t *testing.T) {
+	n1 := newNode("10.0.0.1:9160", 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	cp.AddNode("10.0.0.2:9160")
+	cp.AddNode("10.0.0.2:9160")
+	assert.Equal(t, []NodeInfo{{Addr: "10.0.0.1:9160"}, {Addr: "10.0.0.2:9160"}}, cp.Nodes())
+
+	assert.Equal(t, ErrNodeNotFound, cp.RemoveNode("10.0.0.3:9160"))
+	n1.available.Push(newTestConnection(n1))
+	assert.NoError(t, cp.RemoveNode("10.0.0.1:9160"))
+	assert.True(t, n1.isRemoved())
+	assert.Equal(t, 0, n1.available.Len())
+	assert.Equal(t, []NodeInfo{{Addr: "10.0.0.2:9160"}}, cp.Nodes())
+}
+
+func TestAddNodeHost(t *testing.T) {
+	defer fakeDNS(map[string][]string{"cass.example.com": {"10.0.0.1", "10.0.0.2"}}, nil)()
+	cp := &connectionPool{cluster: &cluster{}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	cp.AddNode("cass.example.com:9160")
+	assert.Equal(t, []NodeInfo{
+		{Addr: "10.0.0.1:9160", Name: "cass.example.com"},
+		{Addr: "10.0.0.2:9160", Name: "cass.example.com"},
+	}, cp.Nodes())
+	assert.Equal(t, []string{"cass.example.com:9160"}, cp.cluster.getSeeds())
+}
+
+func TestRemoveNodeDraining(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	c := newTestConnection(n)
+	c.counted, n.open = true, 1
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+	cp.options.CloseTimeout = time.Millisecond
+	assert.Equal(t, ErrNodeDraining, cp.RemoveNode(localEndpoint))
+
+	// the connection in use is closed when released
+	cp.release(c)
+	assert.Equal(t, int32(0), n.open)
+	assert.True(t, n.drain(0))
+}
+
+func TestMarkDownUp(t *testing.T) {
+	n1 := newNode("10.0.0.1:9160", 0)
+	n2 := newNode("10.0.0.2:9160", 0)
+	n2.available.Push(newTestConnection(n2))
+	var events []NodeEvent
+	options := DefaultPoolOptions
+	options.Grace = -1
+	options.OnNodeStateChange = func(e NodeEvent) { events = append(events, e) }
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+	cp.options.Logger = &testLogger{}
+
+	assert.Equal(t, ErrNodeNotFound, cp.MarkDown("10.0.0.3:9160"))
+	assert.NoError(t, cp.MarkDown("10.0.0.2:9160"))
+	info := cp.Nodes()[1]
+	assert.Equal(t, NodeDown, info.State)
+	assert.True(t, info.MarkedDown)
+	assert.False(t, info.LastFailure.IsZero())
+	assert.Equal(t, 0, info.Idle)
+
+	// it does not recover after Grace like blacklisted nodes
+	now := int(nowfunc().Unix())
+	assert.Equal(t, []*node{n1}, cp.newQueryPlan(nil, now).nodes)
+
+	assert.NoError(t, cp.MarkUp("10.0.0.2:9160"))
+	assert.Equal(t, 2, len(cp.newQueryPlan(nil, now).nodes))
+	assert.Equal(t, []NodeEvent{
+		{Addr: "10.0.0.2:9160", From: NodeUp, To: NodeDown},
+		{Addr: "10.0.0.2:9160", From: NodeDown, To: NodeUp},
+	}, events)
+}
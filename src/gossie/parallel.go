@@ -0,0 +1,354 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	. "github.com/wadey/gossie/src/cassandra"
+)
+
+const (
+	DEFAULT_KEYS_PER_SPLIT = 65536
+	DEFAULT_SPLIT_RETRIES  = 3
+)
+
+// ScanHandler is called by ParallelScan with every row it reads, from several goroutines at the
+// same time. Returning an error stops the scan.
+type ScanHandler func(row *Row) error
+
+// TokenSplit is a part of the token ring scanned by a single worker of ParallelScan
+type TokenSplit struct {
+	StartToken string // exclusive
+	EndToken   string // inclusive
+	RowCount   int64  // number of rows estimated by Cassandra
+}
+
+// ScanCheckpoint stores the splits of a ParallelScan and the ones it completed, so when the scan
+// fails running it again with the same checkpoint scans the same splits, even if the token ring
+// changed meanwhile, and skips the completed ones. Its methods are called concurrently.
+type ScanCheckpoint interface {
+	// Plan returns the splits stored by SetPlan in a previous run, or none to split the ring
+	Plan() ([]TokenSplit, error)
+
+	// SetPlan stores the splits of the scan before any of them is scanned, an error stops the scan
+	SetPlan(splits []TokenSplit) error
+
+	// Completed returns true if the split was completed by a previous run
+	Completed(split TokenSplit) bool
+
+	// Complete stores that every row of the split was handled, an error stops the scan
+	Complete(split TokenSplit) error
+}
+
+// ParallelScanner scans a column family splitting its token ring, see ConnectionPool.ParallelScan
+type ParallelScanner interface {
+
+	// ConsistencyLevel sets the consistency level of the reads, PoolOptions.ReadConsistency by default
+	ConsistencyLevel(ConsistencyLevel) ParallelScanner
+
+	// Slice sets the range of column names to read from every row, see Reader.Slice
+	Slice(slice *Slice) ParallelScanner
+
+	// Where filters the rows like Reader.Where
+	Where(column []byte, op Operator, value []byte) ParallelScanner
+
+	// SplitSize sets the approximate number of rows per split, 65536 by default
+	SplitSize(keys int) ParallelScanner
+
+	// PageSize sets the number of rows read at once, 100 by default
+	PageSize(rows int) ParallelScanner
+
+	// Retries sets how many times a split is scanned before failing the scan, 3 by default. Every
+	// retry resumes after the last page of rows that was handled. Errors that retrying cannot
+	// fix, like an InvalidRequestException or a PanicError, fail the scan right away.
+	Retries(retries int) ParallelScanner
+
+	// Checkpoint sets where the completed splits are stored, see ScanCheckpoint
+	Checkpoint(checkpoint ScanCheckpoint) ParallelScanner
+
+	// Run splits the token ring and scans the splits until all of them are completed, the
+	// handler or the checkpoint fails, a split fails after its retries or ctx is done. It returns
+	// the error that stopped the scan.
+	Run(ctx context.Context) error
+}
+
+type parallelScan struct {
+	reader     *reader
+	workers    int
+	handler    ScanHandler
+	splitSize  int
+	pageSize   int
+	retries    int
+	checkpoint ScanCheckpoint
+}
+
+func (cp *connectionPool) ParallelScan(cf string, workers int, handler ScanHandler) ParallelScanner {
+	r := newReader(cp, cp.options.ReadConsistency)
+	r.Cf(cf)
+	if workers <= 0 {
+		workers = 1
+	}
+	return &parallelScan{
+		reader:    r,
+		workers:   workers,
+		handler:   handler,
+		splitSize: DEFAULT_KEYS_PER_SPLIT,
+		retries:   DEFAULT_SPLIT_RETRIES,
+	}
+}
+
+func (s *parallelScan) ConsistencyLevel(l ConsistencyLevel) ParallelScanner {
+	s.reader.ConsistencyLevel(l)
+	return s
+}
+
+func (s *parallelScan) Slice(slice *Slice) ParallelScanner {
+	s.reader.Slice(slice)
+	return s
+}
+
+func (s *parallelScan) Where(column []byte, op Operator, value []byte) ParallelScanner {
+	s.reader.Where(column, op, value)
+	return s
+}
+
+func (s *parallelScan) SplitSize(keys int) ParallelScanner {
+	s.splitSize = keys
+	return s
+}
+
+func (s *parallelScan) PageSize(rows int) ParallelScanner {
+	s.pageSize = rows
+	return s
+}
+
+func (s *parallelScan) Retries(retries int) ParallelScanner {
+	s.retries = retries
+	return s
+}
+
+func (s *parallelScan) Checkpoint(checkpoint ScanCheckpoint) ParallelScanner {
+	s.checkpoint = checkpoint
+	return s
+}
+
+func (s *parallelScan) Run(ctx context.Context) (err error) {
+	ctx, end := s.reader.startSpan(ctx, "ParallelScan", 0)
+	defer func() { end(err) }()
+
+	splits, err := s.plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	scanCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var once sync.Once
+	fail := func(e error) {
+		once.Do(func() {
+			err = e
+			cancel()
+		})
+	}
+
+	work := make(chan TokenSplit)
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for split := range work {
+				if err := s.scanSplit(scanCtx, split); err != nil {
+					fail(err)
+					return
+				}
+				if s.checkpoint != nil {
+					if err := s.checkpoint.Complete(split); err != nil {
+						fail(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, split := range splits {
+		if s.checkpoint != nil && s.checkpoint.Completed(split) {
+			continue
+		}
+		select {
+		case work <- split:
+		case <-scanCtx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// plan returns the splits stored in the checkpoint, or splits the token ring and stores them
+func (s *parallelScan) plan(ctx context.Context) ([]TokenSplit, error) {
+	if s.checkpoint != nil {
+		splits, err := s.checkpoint.Plan()
+		if err != nil || len(splits) > 0 {
+			return splits, err
+		}
+	}
+	splits, err := s.splits(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.checkpoint != nil {
+		if err := s.checkpoint.SetPlan(splits); err != nil {
+			return nil, err
+		}
+	}
+	return splits, nil
+}
+
+// splits splits every range of the token ring with describe_splits_ex, asking a replica of the
+// range first, which knows how many rows it holds
+func (s *parallelScan) splits(ctx context.Context) ([]TokenSplit, error) {
+	cp := s.reader.pool
+	var ranges []*TokenRange
+	err := cp.runOperation(ctx, &operation{idempotent: true}, func(c *connection) error {
+		var err error
+		ranges, err = c.client.DescribeRing(cp.keyspace)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, ring := cp.cluster.get()
+	var splits []TokenSplit
+	for _, tr := range ranges {
+		op := &operation{idempotent: true}
+		if ring != nil {
+			op.replicas = ring.replicasOf(tr.EndToken)
+		}
+		var cfSplits []*CfSplit
+		err := cp.runOperation(ctx, op, func(c *connection) error {
+			var err error
+			cfSplits, err = c.client.DescribeSplitsEx(s.reader.columnParent.ColumnFamily, tr.StartToken, tr.EndToken, int32(s.splitSize))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(cfSplits) == 0 {
+			splits = append(splits, TokenSplit{StartToken: tr.StartToken, EndToken: tr.EndToken})
+		}
+		for _, cs := range cfSplits {
+			splits = append(splits, TokenSplit{StartToken: cs.StartToken, EndToken: cs.EndToken, RowCount: cs.RowCount})
+		}
+	}
+	return splits, nil
+}
+
+// scanSplit passes the rows of the split to the handler, reading them from the replicas of the
+// split and retrying the reads that fail from the last page that was handled
+func (s *parallelScan) scanSplit(ctx context.Context, split TokenSplit) error {
+	kr := NewKeyRange()
+	kr.StartToken = &split.StartToken
+	kr.EndToken = &split.EndToken
+	if s.pageSize > 0 {
+		kr.Count = int32(s.pageSize)
+	}
+	if len(s.reader.expressions) != 0 {
+		kr.RowFilter = s.reader.expressions
+	}
+
+	var replicas []*node
+	if _, ring := s.reader.pool.cluster.get(); ring != nil {
+		replicas = ring.replicasOf(split.EndToken)
+	}
+	var handlerErr error
+	handle := func(row *Row) error {
+		handlerErr = s.handler(row)
+		return handlerErr
+	}
+	var err error
+	for tries := 0; tries < s.retries || tries == 0; tries++ {
+		err = s.reader.scan(ctx, kr, replicas, handle)
+		if err == nil || handlerErr != nil || ctx.Err() != nil || !retryableScanError(err) {
+			return err
+		}
+		s.reader.pool.options.Logger.Warn("Scan of split failed", "start", split.StartToken, "end", split.EndToken, "attempt", tries+1, "error", err)
+	}
+	return err
+}
+
+// retryableScanError reports if scanning a split again may fix the error
+func retryableScanError(err error) bool {
+	var invalid *InvalidRequestException
+	var panicked *PanicError
+	return !errors.As(err, &invalid) && !errors.As(err, &panicked)
+}
+
+// MemoryCheckpoint is a ScanCheckpoint that keeps the splits in memory. Plan and Splits return
+// the splits of the scan and the completed ones, to store them somewhere else, and
+// NewMemoryCheckpoint loads them back.
+type MemoryCheckpoint struct {
+	plan      []TokenSplit
+	completed map[[2]string]TokenSplit
+	m         sync.Mutex
+}
+
+// NewMemoryCheckpoint returns a checkpoint with the given splits of the scan, which can be nil,
+// and completed splits
+func NewMemoryCheckpoint(plan, completed []TokenSplit) *MemoryCheckpoint {
+	c := &MemoryCheckpoint{
+		plan:      plan,
+		completed: make(map[[2]string]TokenSplit, len(completed)),
+	}
+	for _, split := range completed {
+		c.completed[[2]string{split.StartToken, split.EndToken}] = split
+	}
+	return c
+}
+
+func (c *MemoryCheckpoint) Plan() ([]TokenSplit, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.plan, nil
+}
+
+func (c *MemoryCheckpoint) SetPlan(splits []TokenSplit) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.plan = splits
+	return nil
+}
+
+func (c *MemoryCheckpoint) Completed(split TokenSplit) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	_, ok := c.completed[[2]string{split.StartToken, split.EndToken}]
+	return ok
+}
+
+func (c *MemoryCheckpoint) Complete(split TokenSplit) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.completed[[2]string{split.StartToken, split.EndToken}] = split
+	return nil
+}
+
+// Splits returns the completed splits
+func (c *MemoryCheckpoint) Splits() []TokenSplit {
+	c.m.Lock()
+	defer c.m.Unlock()
+	splits := make([]TokenSplit, 0, len(c.completed))
+	for _, split := range c.completed {
+		splits = append(splits, split)
+	}
+	return splits
+}
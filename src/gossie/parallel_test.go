@@ -0,0 +1,179 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func testSplitRange(start, end string, key []byte) *KeyRange {
+	kr := NewKeyRange()
+	if key != nil {
+		kr.StartKey = key
+	} else {
+		kr.StartToken = &start
+	}
+	kr.EndToken = &end
+	return kr
+}
+
+// expectSplit expects the scan of a split with a single row
+func expectSplit(cli *mock_cassandra.MockCassandra, start, end, key string) {
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange(start, end, nil), gomock.Any()).Return(testKeySlices(key), nil),
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange(start, end, []byte(key)), gomock.Any()).Return(testKeySlices(key), nil),
+	)
+}
+
+func testScanPool(cli Cassandra, connections int) *connectionPool {
+	n := newNode(localEndpoint, 0)
+	for i := 0; i < connections; i++ {
+		c := newTestConnection(n)
+		c.client = cli
+		n.available.Push(c)
+	}
+	cp := &connectionPool{keyspace: "ks", cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+	return cp
+}
+
+type keyCollector struct {
+	keys []string
+	m    sync.Mutex
+}
+
+func (k *keyCollector) handle(row *Row) error {
+	k.m.Lock()
+	defer k.m.Unlock()
+	k.keys = append(k.keys, string(row.Key))
+	return nil
+}
+
+func (k *keyCollector) sorted() []string {
+	sort.Strings(k.keys)
+	return k.keys
+}
+
+func TestParallelScan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cli.EXPECT().DescribeRing("ks").Return([]*TokenRange{
+		&TokenRange{StartToken: "0", EndToken: "50"},
+		&TokenRange{StartToken: "50", EndToken: "0"},
+	}, nil)
+	cli.EXPECT().DescribeSplitsEx("cf", "0", "50", int32(1000)).Return([]*CfSplit{
+		&CfSplit{StartToken: "0", EndToken: "25", RowCount: 900},
+		&CfSplit{StartToken: "25", EndToken: "50", RowCount: 800},
+	}, nil)
+	cli.EXPECT().DescribeSplitsEx("cf", "50", "0", int32(1000)).Return(nil, nil)
+	expectSplit(cli, "0", "25", "a")
+	expectSplit(cli, "25", "50", "b")
+	expectSplit(cli, "50", "0", "c")
+
+	var k keyCollector
+	cp := testScanPool(cli, 2)
+	err := cp.ParallelScan("cf", 2, k.handle).SplitSize(1000).Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, k.sorted())
+}
+
+func TestParallelScanCheckpoint(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cli.EXPECT().DescribeRing("ks").Return([]*TokenRange{&TokenRange{StartToken: "0", EndToken: "0"}}, nil)
+	cli.EXPECT().DescribeSplitsEx("cf", "0", "0", int32(DEFAULT_KEYS_PER_SPLIT)).Return([]*CfSplit{
+		&CfSplit{StartToken: "0", EndToken: "50"},
+		&CfSplit{StartToken: "50", EndToken: "0"},
+	}, nil)
+	expectSplit(cli, "0", "50", "a")
+	failed := errors.New("handler failed")
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange("50", "0", nil), gomock.Any()).Return(testKeySlices("b"), nil)
+	cp := testScanPool(cli, 1)
+	cp.options.Retries = 1
+	checkpoint := NewMemoryCheckpoint(nil, nil)
+
+	err := cp.ParallelScan("cf", 1, func(row *Row) error {
+		if string(row.Key) == "b" {
+			return failed
+		}
+		return nil
+	}).Checkpoint(checkpoint).Run(context.Background())
+	assert.Equal(t, failed, err)
+	assert.Equal(t, []TokenSplit{TokenSplit{StartToken: "0", EndToken: "50"}}, checkpoint.Splits())
+	plan, _ := checkpoint.Plan()
+	assert.Equal(t, 2, len(plan))
+
+	// the boundaries of the splits changed, the second run still scans the split that failed
+	// only, retrying the read that fails
+	cli.EXPECT().DescribeRing("ks").Return([]*TokenRange{&TokenRange{StartToken: "0", EndToken: "0"}}, nil).AnyTimes()
+	cli.EXPECT().DescribeSplitsEx("cf", "0", "0", int32(DEFAULT_KEYS_PER_SPLIT)).Return([]*CfSplit{
+		&CfSplit{StartToken: "0", EndToken: "40"},
+		&CfSplit{StartToken: "40", EndToken: "0"},
+	}, nil).AnyTimes()
+	unavailable := NewUnavailableException()
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange("50", "0", nil), gomock.Any()).Return(testKeySlices("b"), nil),
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange("50", "0", []byte("b")), gomock.Any()).Return(nil, unavailable),
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), testSplitRange("50", "0", []byte("b")), gomock.Any()).Return(testKeySlices("b"), nil),
+	)
+	var k keyCollector
+	checkpoint = NewMemoryCheckpoint(plan, checkpoint.Splits())
+	err = cp.ParallelScan("cf", 1, k.handle).Checkpoint(checkpoint).Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, k.keys)
+	assert.Equal(t, 2, len(checkpoint.Splits()))
+}
+
+func TestParallelScanInvalidRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	invalid := &InvalidRequestException{Why: "invalid"}
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, invalid)
+	cp := testScanPool(cli, 1)
+	checkpoint := NewMemoryCheckpoint([]TokenSplit{TokenSplit{StartToken: "0", EndToken: "0"}}, nil)
+
+	// the split is not scanned again
+	var k keyCollector
+	err := cp.ParallelScan("cf", 1, k.handle).Checkpoint(checkpoint).Run(context.Background())
+	assert.True(t, errors.Is(err, invalid))
+	assert.Empty(t, checkpoint.Splits())
+}
+
+func TestParallelScanReplicas(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli1 := mock_cassandra.NewMockCassandra(ctrl)
+	cli2 := mock_cassandra.NewMockCassandra(ctrl)
+	n1 := newNode("10.0.0.1:9160", 0)
+	n2 := newNode("10.0.0.2:9160", 0)
+	for n, cli := range map[*node]Cassandra{n1: cli1, n2: cli2} {
+		c := newTestConnection(n)
+		c.client = cli
+		n.available.Push(c)
+	}
+	ring, err := newTokenRing(murmur3Partitioner{}, []*TokenRange{
+		&TokenRange{StartToken: "0", EndToken: "100", Endpoints: []string{"10.0.0.2"}},
+		&TokenRange{StartToken: "100", EndToken: "0", Endpoints: []string{"10.0.0.1"}},
+	}, hostIndex([]*node{n1, n2}))
+	assert.NoError(t, err)
+	cp := &connectionPool{keyspace: "ks", cluster: &cluster{nodes: []*node{n1, n2}, ring: ring}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	// the split ends in the range owned by the second node
+	expectSplit(cli2, "0", "50", "a")
+	checkpoint := NewMemoryCheckpoint([]TokenSplit{TokenSplit{StartToken: "0", EndToken: "50"}}, nil)
+	var k keyCollector
+	err = cp.ParallelScan("cf", 1, k.handle).Checkpoint(checkpoint).Run(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, k.keys)
+}
@@ -0,0 +1,214 @@
+package gossie
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Host is a node of a ConnectionPool as seen by a LoadBalancingPolicy
+type Host interface {
+	// Addr returns the "host:port" address of the node
+	Addr() string
+
+	// Outstanding returns the number of transactions currently running on the node
+	Outstanding() int
+}
+
+// LoadBalancingPolicy decides the order in which the nodes of a ConnectionPool are tried
+// for a transaction
+type LoadBalancingPolicy interface {
+	// QueryPlan orders the candidate nodes for a transaction. The pool calls it with the nodes
+	// that are not blacklisted, once for each group of equally preferred nodes: first the local
+	// replicas of the row key (when the key and the token ring are known), then the rest of the
+	// local nodes and finally the allowed remote nodes. The returned nodes are tried in order,
+	// one per retry, and nodes can be left out to never use them.
+	QueryPlan(candidates []Host) []Host
+}
+
+// NewRandomPolicy returns a LoadBalancingPolicy that tries the nodes in random order
+func NewRandomPolicy() LoadBalancingPolicy {
+	return randomPolicy{}
+}
+
+type randomPolicy struct{}
+
+func (randomPolicy) QueryPlan(candidates []Host) []Host {
+	plan := make([]Host, len(candidates))
+	for i, j := range rand.Perm(len(candidates)) {
+		plan[i] = candidates[j]
+	}
+	return plan
+}
+
+// NewRoundRobinPolicy returns a LoadBalancingPolicy that rotates the first node to try on
+// every transaction
+func NewRoundRobinPolicy() LoadBalancingPolicy {
+	return &roundRobinPolicy{}
+}
+
+type roundRobinPolicy struct {
+	next uint32
+}
+
+func (p *roundRobinPolicy) QueryPlan(candidates []Host) []Host {
+	n := len(candidates)
+	if n == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint32(&p.next, 1) % uint32(n))
+	plan := make([]Host, 0, n)
+	plan = append(plan, candidates[start:]...)
+	return append(plan, candidates[:start]...)
+}
+
+// NewWeightedPolicy returns a LoadBalancingPolicy that tries the nodes in a random order where
+// each node is picked first with a probability proportional to its weight. weights is indexed
+// by "host:port" address, nodes not present have a weight of 1 and nodes with a weight of 0 or
+// less are never used. If no weight is above 0 they are all ignored and every node has the same
+// weight, so the pool is never left without nodes.
+func NewWeightedPolicy(weights map[string]int) LoadBalancingPolicy {
+	w := make(map[string]int, len(weights))
+	for addr, weight := range weights {
+		w[addr] = weight
+	}
+	for _, weight := range w {
+		if weight > 0 {
+			return &weightedPolicy{weights: w}
+		}
+	}
+	return &weightedPolicy{}
+}
+
+type weightedPolicy struct {
+	weights map[string]int
+}
+
+type weightedHosts struct {
+	hosts []Host
+	keys  []float64
+}
+
+func (w *weightedHosts) Len() int           { return len(w.hosts) }
+func (w *weightedHosts) Less(i, j int) bool { return w.keys[i] < w.keys[j] }
+func (w *weightedHosts) Swap(i, j int) {
+	w.hosts[i], w.hosts[j] = w.hosts[j], w.hosts[i]
+	w.keys[i], w.keys[j] = w.keys[j], w.keys[i]
+}
+
+func (p *weightedPolicy) QueryPlan(candidates []Host) []Host {
+	// weighted random shuffle: sort by -ln(u)/weight
+	w := &weightedHosts{}
+	for _, h := range candidates {
+		weight, ok := p.weights[h.Addr()]
+		if !ok {
+			weight = 1
+		}
+		if weight <= 0 {
+			continue
+		}
+		w.hosts = append(w.hosts, h)
+		w.keys = append(w.keys, -math.Log(1-rand.Float64())/float64(weight))
+	}
+	sort.Sort(w)
+	return w.hosts
+}
+
+// NewLeastOutstandingPolicy returns a LoadBalancingPolicy that tries first the nodes with the
+// fewest transactions in flight, breaking ties randomly
+func NewLeastOutstandingPolicy() LoadBalancingPolicy {
+	return leastOutstandingPolicy{}
+}
+
+type leastOutstandingPolicy struct{}
+
+type byOutstanding []Host
+
+func (b byOutstanding) Len() int           { return len(b) }
+func (b byOutstanding) Less(i, j int) bool { return b[i].Outstanding() < b[j].Outstanding() }
+func (b byOutstanding) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+func (leastOutstandingPolicy) QueryPlan(candidates []Host) []Host {
+	plan := randomPolicy{}.QueryPlan(candidates)
+	sort.Stable(byOutstanding(plan))
+	return plan
+}
+
+func (n *node) Addr() string {
+	return n.node
+}
+
+func (n *node) Outstanding() int {
+	return int(atomic.LoadInt32(&n.outstanding))
+}
+
+// queryPlan holds the nodes to try for a transaction, walked in order across retries
+type queryPlan struct {
+	nodes []*node
+	next  int
+	m     sync.Mutex
+}
+
+// nextNode returns the next node of the plan that is not blacklisted, starting over when the
+// end of the plan is reached, or nil if all of them are blacklisted. A recovering node is only
+// returned for its trial transaction.
+func (p *queryPlan) nextNode(cp *connectionPool, now int) *node {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for tries := 0; tries < len(p.nodes); tries++ {
+		n := p.nodes[p.next%len(p.nodes)]
+		p.next++
+		if cp.pick(n, now) {
+			return n
+		}
+	}
+	return nil
+}
+
+// newQueryPlan returns the plan for a transaction on the given row key, which can be nil
+func (cp *connectionPool) newQueryPlan(key []byte, now int) *queryPlan {
+	var replicas []*node
+	if _, ring := cp.cluster.get(); key != nil && ring != nil {
+		replicas = ring.replicasFor(key)
+	}
+	return cp.replicaQueryPlan(replicas, now)
+}
+
+// replicaQueryPlan returns the plan for a transaction that is sent to the given replicas first,
+// the ones in the local datacenter if PoolOptions.LocalDatacenter is set
+func (cp *connectionPool) replicaQueryPlan(replicas []*node, now int) *queryPlan {
+	local, remote := cp.cluster.byDatacenter(cp.options.LocalDatacenter, cp.options.RemoteNodes)
+	replicas = cp.cluster.local(replicas, cp.options.LocalDatacenter)
+	isReplica := make(map[*node]bool, len(replicas))
+	for _, n := range replicas {
+		isReplica[n] = true
+	}
+
+	var plan []*node
+	plan = cp.appendPlan(plan, replicas, now, nil)
+	plan = cp.appendPlan(plan, local, now, isReplica)
+	plan = cp.appendPlan(plan, remote, now, isReplica)
+	return &queryPlan{nodes: plan}
+}
+
+// appendPlan adds to plan the nodes that are not blacklisted nor in skip, in the order chosen
+// by the LoadBalancingPolicy
+func (cp *connectionPool) appendPlan(plan, nodes []*node, now int, skip map[*node]bool) []*node {
+	candidates := make([]Host, 0, len(nodes))
+	for _, n := range nodes {
+		if !skip[n] && !n.isRemoved() && cp.isUp(n, now) {
+			candidates = append(candidates, n)
+		}
+	}
+	if len(candidates) == 0 {
+		return plan
+	}
+	for _, h := range cp.options.LoadBalancingPolicy.QueryPlan(candidates) {
+		if n, ok := h.(*node); ok {
+			plan = append(plan, n)
+		}
+	}
+	return plan
+}
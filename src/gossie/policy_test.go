@@ -0,0 +1,88 @@
+package gossie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHost struct {
+	addr        string
+	outstanding int
+}
+
+func (h *testHost) Addr() string     { return h.addr }
+func (h *testHost) Outstanding() int { return h.outstanding }
+
+func testHosts() []Host {
+	return []Host{
+		&testHost{addr: "a:9160", outstanding: 3},
+		&testHost{addr: "b:9160", outstanding: 1},
+		&testHost{addr: "c:9160", outstanding: 2},
+	}
+}
+
+func TestRandomPolicy(t *testing.T) {
+	hosts := testHosts()
+	plan := NewRandomPolicy().QueryPlan(hosts)
+	assert.Equal(t, 3, len(plan))
+	for _, h := range hosts {
+		assert.Contains(t, plan, h)
+	}
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	hosts := testHosts()
+	p := NewRoundRobinPolicy()
+	first := p.QueryPlan(hosts)
+	second := p.QueryPlan(hosts)
+	third := p.QueryPlan(hosts)
+	assert.Equal(t, 3, len(first))
+	assert.Equal(t, first[1], second[0])
+	assert.Equal(t, first[2], third[0])
+	assert.Equal(t, first, p.QueryPlan(hosts))
+	assert.Empty(t, p.QueryPlan(nil))
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	hosts := testHosts()
+	p := NewWeightedPolicy(map[string]int{"a:9160": 1000, "b:9160": 0})
+	firsts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		plan := p.QueryPlan(hosts)
+		assert.Equal(t, 2, len(plan))
+		firsts[plan[0].Addr()]++
+	}
+	assert.Equal(t, 0, firsts["b:9160"])
+	assert.True(t, firsts["a:9160"] > 90)
+
+	// all the nodes are used with the same weight when every weight is 0
+	p = NewWeightedPolicy(map[string]int{"a:9160": 0, "b:9160": 0, "c:9160": 0})
+	assert.Equal(t, 3, len(p.QueryPlan(hosts)))
+}
+
+func TestLeastOutstandingPolicy(t *testing.T) {
+	plan := NewLeastOutstandingPolicy().QueryPlan(testHosts())
+	assert.Equal(t, "b:9160", plan[0].Addr())
+	assert.Equal(t, "c:9160", plan[1].Addr())
+	assert.Equal(t, "a:9160", plan[2].Addr())
+}
+
+func TestQueryPlanWraps(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9160"}
+	n2 := &node{node: "10.0.0.2:9160"}
+	options := DefaultPoolOptions
+	options.LoadBalancingPolicy = NewRoundRobinPolicy()
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+	now := int(nowfunc().Unix())
+
+	plan := cp.newQueryPlan(nil, now)
+	first := plan.nextNode(cp, now)
+	second := plan.nextNode(cp, now)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, plan.nextNode(cp, now))
+
+	second.blacklist()
+	assert.Equal(t, first, plan.nextNode(cp, now))
+	assert.Equal(t, first, plan.nextNode(cp, now))
+}
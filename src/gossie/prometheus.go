@@ -0,0 +1,197 @@
+package gossie
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the operation latency histogram
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// PrometheusCollector is a MetricsCollector that keeps the counters and the latency histogram
+// of a pool so they can be exposed in the Prometheus text format, see Handler
+type PrometheusCollector struct {
+	nodes      map[string]*nodeMetrics
+	operations uint64
+	errors     uint64
+	buckets    []uint64 // cumulative counts of latencyBuckets
+	sum        float64
+	m          sync.Mutex
+}
+
+type nodeMetrics struct {
+	acquired    uint64
+	waitSeconds float64
+	released    uint64
+	expired     uint64
+	bled        uint64
+	blacklisted uint64
+	retries     uint64
+}
+
+// NewPrometheusCollector returns an empty PrometheusCollector, set it as the MetricsCollector of
+// the PoolOptions
+func NewPrometheusCollector() *PrometheusCollector {
+	return &PrometheusCollector{
+		nodes:   make(map[string]*nodeMetrics),
+		buckets: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+func (p *PrometheusCollector) node(node string) *nodeMetrics {
+	m, ok := p.nodes[node]
+	if !ok {
+		m = &nodeMetrics{}
+		p.nodes[node] = m
+	}
+	return m
+}
+
+func (p *PrometheusCollector) ConnectionAcquired(node string, wait time.Duration) {
+	p.m.Lock()
+	m := p.node(node)
+	m.acquired++
+	m.waitSeconds += wait.Seconds()
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) ConnectionReleased(node string) {
+	p.m.Lock()
+	p.node(node).released++
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) ConnectionExpired(node string) {
+	p.m.Lock()
+	p.node(node).expired++
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) ConnectionBled(node string) {
+	p.m.Lock()
+	p.node(node).bled++
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) NodeBlacklisted(node string, err error) {
+	p.m.Lock()
+	p.node(node).blacklisted++
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) Retried(node string, err error) {
+	p.m.Lock()
+	p.node(node).retries++
+	p.m.Unlock()
+}
+
+func (p *PrometheusCollector) OperationDone(latency time.Duration, err error) {
+	s := latency.Seconds()
+	p.m.Lock()
+	p.operations++
+	if err != nil {
+		p.errors++
+	}
+	for i, le := range latencyBuckets {
+		if s <= le {
+			p.buckets[i]++
+		}
+	}
+	p.sum += s
+	p.m.Unlock()
+}
+
+// Handler returns an http.Handler that writes the collected values in the Prometheus text
+// format. If pool is not nil the connection counts and node states of its Stats are written too.
+func (p *PrometheusCollector) Handler(pool ConnectionPool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		b := bufio.NewWriter(w)
+		p.write(b)
+		if pool != nil {
+			writeStats(b, pool.Stats())
+		}
+		b.Flush()
+	})
+}
+
+func (p *PrometheusCollector) write(w *bufio.Writer) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	addrs := make([]string, 0, len(p.nodes))
+	for addr := range p.nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	perNode := func(name, typ, help string, value func(*nodeMetrics) float64) {
+		header(w, name, typ, help)
+		for _, addr := range addrs {
+			fmt.Fprintf(w, "%s{node=%q} %s\n", name, addr, formatFloat(value(p.nodes[addr])))
+		}
+	}
+	perNode("gossie_connections_acquired_total", "counter", "Connections handed to transactions.",
+		func(m *nodeMetrics) float64 { return float64(m.acquired) })
+	perNode("gossie_connections_acquire_wait_seconds_total", "counter", "Time spent waiting for connections.",
+		func(m *nodeMetrics) float64 { return m.waitSeconds })
+	perNode("gossie_connections_released_total", "counter", "Connections returned idle to the pool.",
+		func(m *nodeMetrics) float64 { return float64(m.released) })
+	perNode("gossie_connections_expired_total", "counter", "Released connections closed because they expired.",
+		func(m *nodeMetrics) float64 { return float64(m.expired) })
+	perNode("gossie_connections_bled_total", "counter", "Idle connections closed by the bleeder.",
+		func(m *nodeMetrics) float64 { return float64(m.bled) })
+	perNode("gossie_node_blacklisted_total", "counter", "Times the node went down.",
+		func(m *nodeMetrics) float64 { return float64(m.blacklisted) })
+	perNode("gossie_retries_total", "counter", "Failed attempts that were retried.",
+		func(m *nodeMetrics) float64 { return float64(m.retries) })
+
+	header(w, "gossie_operation_errors_total", "counter", "Transactions that returned an error.")
+	fmt.Fprintf(w, "gossie_operation_errors_total %d\n", p.errors)
+
+	header(w, "gossie_operation_duration_seconds", "histogram", "Duration of the transactions, including retries.")
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "gossie_operation_duration_seconds_bucket{le=%q} %d\n", formatFloat(le), p.buckets[i])
+	}
+	fmt.Fprintf(w, "gossie_operation_duration_seconds_bucket{le=\"+Inf\"} %d\n", p.operations)
+	fmt.Fprintf(w, "gossie_operation_duration_seconds_sum %s\n", formatFloat(p.sum))
+	fmt.Fprintf(w, "gossie_operation_duration_seconds_count %d\n", p.operations)
+}
+
+func writeStats(w *bufio.Writer, s PoolStats) {
+	header(w, "gossie_operations_running", "gauge", "Transactions in progress.")
+	fmt.Fprintf(w, "gossie_operations_running %d\n", s.Running)
+
+	perNode := func(name, help string, value func(NodeStats) int) {
+		header(w, name, "gauge", help)
+		for _, n := range s.Nodes {
+			fmt.Fprintf(w, "%s{node=%q} %d\n", name, n.Addr, value(n))
+		}
+	}
+	perNode("gossie_connections_open", "Open connections.", func(n NodeStats) int { return n.Open })
+	perNode("gossie_connections_idle", "Open connections ready to be used.", func(n NodeStats) int { return n.Idle })
+	perNode("gossie_connections_in_use", "Open connections running a transaction.", func(n NodeStats) int { return n.InUse })
+
+	header(w, "gossie_node_state", "gauge", "1 for the current state of the node.")
+	for _, n := range s.Nodes {
+		for _, state := range []NodeState{NodeUp, NodeDown, NodeRecovering} {
+			value := 0
+			if n.State == state {
+				value = 1
+			}
+			fmt.Fprintf(w, "gossie_node_state{node=%q,state=%q} %d\n", n.Addr, state, value)
+		}
+	}
+}
+
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -1,6 +1,7 @@
 package gossie
 
 import (
+	"context"
 	"errors"
 	"reflect"
 
@@ -55,6 +56,9 @@ type Query interface {
 	// scan range filtered by Where statement(s)
 	RangeGet(*Range) (Result, error)
 
+	// RangeGetContext is like RangeGet but it gives up when ctx is done, returning ctx.Err()
+	RangeGetContext(context.Context, *Range) (Result, error)
+
 	// scan range for one record and unmarshal it into destination
 	RangeOne(destination interface{}) error
 
@@ -62,11 +66,17 @@ type Query interface {
 	// column names the Result will allow you to iterate over the entire row.
 	Get(key interface{}) (Result, error)
 
+	// GetContext is like Get but it gives up when ctx is done, returning ctx.Err()
+	GetContext(ctx context.Context, key interface{}) (Result, error)
+
 	// Like Get() but returns exactly one record or error
 	GetOne(key interface{}, destination interface{}) error
 
 	// MultiGet looks up multiple rows given the keys.
 	MultiGet(keys []interface{}) (Result, error)
+
+	// MultiGetContext is like MultiGet but it gives up when ctx is done, returning ctx.Err()
+	MultiGetContext(ctx context.Context, keys []interface{}) (Result, error)
 }
 
 // Result reads Query results into Go objects, internally buffering them.
@@ -140,6 +150,10 @@ func (q *query) Get(key interface{}) (Result, error) {
 	return q.MultiGet([]interface{}{key})
 }
 
+func (q *query) GetContext(ctx context.Context, key interface{}) (Result, error) {
+	return q.MultiGetContext(ctx, []interface{}{key})
+}
+
 func (q *query) GetOne(key interface{}, destination interface{}) error {
 	res, err := q.Get(key)
 	if err != nil {
@@ -168,7 +182,12 @@ func (q *query) Where(field string, op Operator, val interface{}) Query {
 }
 
 func (q *query) MultiGet(keys []interface{}) (Result, error) {
-	var err error
+	return q.MultiGetContext(context.Background(), keys)
+}
+
+func (q *query) MultiGetContext(ctx context.Context, keys []interface{}) (res Result, err error) {
+	ctx, end := q.startSpan(ctx, "Query.MultiGet", len(keys))
+	defer func() { end(err) }()
 
 	keysB := make([][]byte, 0)
 
@@ -185,7 +204,7 @@ func (q *query) MultiGet(keys []interface{}) (Result, error) {
 	var rows []*Row
 
 	if len(keysB) == 1 {
-		row, err := q.reader.Get(keysB[0])
+		row, err := q.reader.GetContext(ctx, keysB[0])
 		if err != nil {
 			return nil, err
 		}
@@ -193,7 +212,7 @@ func (q *query) MultiGet(keys []interface{}) (Result, error) {
 			rows = []*Row{row}
 		}
 	} else {
-		rows, err = q.reader.MultiGet(keysB)
+		rows, err = q.reader.MultiGetContext(ctx, keysB)
 		if err != nil {
 			return nil, err
 		}
@@ -203,14 +222,26 @@ func (q *query) MultiGet(keys []interface{}) (Result, error) {
 }
 
 func (q *query) RangeGet(r *Range) (Result, error) {
+	return q.RangeGetContext(context.Background(), r)
+}
+
+func (q *query) RangeGetContext(ctx context.Context, r *Range) (res Result, err error) {
+	ctx, end := q.startSpan(ctx, "Query.RangeGet", 0)
+	defer func() { end(err) }()
+
 	q.buildSlice(q.reader)
-	rows, err := q.reader.RangeGet(r)
+	rows, err := q.reader.RangeGetContext(ctx, r)
 	if err != nil {
 		return nil, err
 	}
 	return &result{query: *q, buffer: rows}, nil
 }
 
+// startSpan starts tracing a query, the operations of its reader are traced as its children
+func (q *query) startSpan(ctx context.Context, name string, keys int) (context.Context, func(error)) {
+	return q.pool.startSpan(ctx, OperationInfo{Name: name, ColumnFamily: q.mapping.Cf(), Keys: keys})
+}
+
 func (q *query) buildSlice(reader Reader) error {
 	var start, end []byte
 
@@ -340,25 +371,47 @@ func (r *result) Next(destination interface{}) error {
 	return err
 }
 
+// StreamingResult is a Result over the rows of a scan, read as they arrive. Next returns the error
+// that stopped the scan, or Done at the end of it.
 type StreamingResult struct {
 	Mapping
+	Scanner RowScanner
+
+	// Deprecated, please set Scanner instead. RowsChannel is read if Scanner is not set, for the
+	// data channel of RangeScan.
 	RowsChannel <-chan *Row
-	row         *Row
-	position    int
+
+	row      *Row
+	position int
 }
 
 func (r *StreamingResult) feedRow() error {
 	if r.row == nil {
-		var ok bool
-		r.row, ok = <-r.RowsChannel
-		if !ok {
-			return Done
+		if r.Scanner != nil {
+			row, err := r.Scanner.Next()
+			if err != nil {
+				return err
+			}
+			r.row = row
+		} else {
+			var ok bool
+			r.row, ok = <-r.RowsChannel
+			if !ok {
+				return Done
+			}
 		}
 		r.position = 0
 	}
 	return nil
 }
 
+// Close stops the scan of Scanner
+func (r *StreamingResult) Close() {
+	if r.Scanner != nil {
+		r.Scanner.Close()
+	}
+}
+
 func (r *StreamingResult) Key() ([]byte, error) {
 	if err := r.feedRow(); err != nil {
 		return nil, err
@@ -0,0 +1,183 @@
+package gossie
+
+import (
+	enc "encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+var ErrTraceNotFound = errors.New("Trace session not found")
+
+// TraceSession is a request traced by Cassandra, as stored in system_traces.sessions
+type TraceSession struct {
+	ID          UUID
+	Coordinator net.IP
+	Request     string
+	Parameters  map[string]string
+	StartedAt   time.Time
+	Duration    time.Duration // 0 while the request is still running
+	Events      []*TraceEvent
+}
+
+// TraceEvent is a step of a traced request, as stored in system_traces.events
+type TraceEvent struct {
+	ID       UUID
+	Activity string
+	Source   net.IP        // node that ran the step
+	Elapsed  time.Duration // since the request started on Source
+	Thread   string
+}
+
+// traceSession receives the session of the requests traced for a Reader or Writer call
+type traceSession struct {
+	session *UUID
+	m       sync.Mutex
+}
+
+func newTraceSession(session *UUID) *traceSession {
+	if session == nil {
+		return nil
+	}
+	return &traceSession{session: session}
+}
+
+// wrap returns a transaction that asks Cassandra to trace the request sent by t. The session is
+// stored even if t fails, as failed and slow requests are the ones worth looking at.
+func (s *traceSession) wrap(t transaction) transaction {
+	return func(c *connection) error {
+		id, err := c.client.TraceNextQuery()
+		if err != nil {
+			return err
+		}
+		err = t(c)
+		s.m.Lock()
+		copy(s.session[:], id)
+		s.m.Unlock()
+		return err
+	}
+}
+
+func (cp *connectionPool) QueryTrace(session UUID) (*TraceSession, error) {
+	var sessions, events *cassandra.CqlResult_
+	err := cp.run(func(c *connection) error {
+		var err error
+		sessions, err = c.client.ExecuteCql3Query([]byte("SELECT session_id, coordinator, request, parameters, started_at, duration "+
+			"FROM system_traces.sessions WHERE session_id = "+session.String()), cassandra.Compression_NONE, cassandra.ConsistencyLevel_ONE)
+		if err != nil {
+			return err
+		}
+		events, err = c.client.ExecuteCql3Query([]byte("SELECT event_id, activity, source, source_elapsed, thread "+
+			"FROM system_traces.events WHERE session_id = "+session.String()), cassandra.Compression_NONE, cassandra.ConsistencyLevel_ONE)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions.Rows) == 0 {
+		return nil, ErrTraceNotFound
+	}
+	return traceFromCql(sessions.Rows[0], events.Rows)
+}
+
+// traceFromCql builds a TraceSession from the rows of system_traces.sessions and events. Columns
+// with null values are missing or empty and leave the field unset.
+func traceFromCql(row *cassandra.CqlRow, events []*cassandra.CqlRow) (*TraceSession, error) {
+	s := &TraceSession{}
+	for _, col := range row.Columns {
+		if len(col.Value) == 0 {
+			continue
+		}
+		var err error
+		switch string(col.Name) {
+		case "session_id":
+			err = unmarshalUUID(col.Value, UUIDType, &s.ID)
+		case "coordinator":
+			s.Coordinator = net.IP(col.Value)
+		case "request":
+			s.Request = string(col.Value)
+		case "parameters":
+			s.Parameters, err = unmarshalStringMap(col.Value)
+		case "started_at":
+			err = unmarshalTime(col.Value, DateType, &s.StartedAt)
+		case "duration":
+			s.Duration, err = unmarshalMicros(col.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, row := range events {
+		e := &TraceEvent{}
+		for _, col := range row.Columns {
+			if len(col.Value) == 0 {
+				continue
+			}
+			var err error
+			switch string(col.Name) {
+			case "event_id":
+				err = unmarshalUUID(col.Value, TimeUUIDType, &e.ID)
+			case "activity":
+				e.Activity = string(col.Value)
+			case "source":
+				e.Source = net.IP(col.Value)
+			case "source_elapsed":
+				e.Elapsed, err = unmarshalMicros(col.Value)
+			case "thread":
+				e.Thread = string(col.Value)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.Events = append(s.Events, e)
+	}
+	return s, nil
+}
+
+// unmarshalMicros reads the durations in microseconds of the traces, stored as 32 bit ints
+func unmarshalMicros(b []byte) (time.Duration, error) {
+	if len(b) != 4 {
+		return 0, ErrorCassandraTypeSerializationUnmarshaling
+	}
+	return time.Duration(int32(enc.BigEndian.Uint32(b))) * time.Microsecond, nil
+}
+
+// unmarshalStringMap reads a map<text, text> as serialized for Thrift clients: the number of
+// entries followed by the keys and values, each one prefixed by its length as 16 bit ints
+func unmarshalStringMap(b []byte) (map[string]string, error) {
+	next := func() (string, bool) {
+		if len(b) < 2 {
+			return "", false
+		}
+		l := int(enc.BigEndian.Uint16(b))
+		if len(b) < 2+l {
+			return "", false
+		}
+		s := string(b[2 : 2+l])
+		b = b[2+l:]
+		return s, true
+	}
+	if len(b) < 2 {
+		return nil, ErrorCassandraTypeSerializationUnmarshaling
+	}
+	n := int(enc.BigEndian.Uint16(b))
+	b = b[2:]
+	m := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		k, ok := next()
+		if !ok {
+			return nil, ErrorCassandraTypeSerializationUnmarshaling
+		}
+		v, ok := next()
+		if !ok {
+			return nil, ErrorCassandraTypeSerializationUnmarshaling
+		}
+		m[k] = v
+	}
+	return m, nil
+}
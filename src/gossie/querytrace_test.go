@@ -0,0 +1,77 @@
+package gossie
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func TestWriterTrace(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	session, _ := ParseUUID("5d8ae3d0-2a79-11e3-8c0f-f3bbd8d4e2c5")
+	gomock.InOrder(
+		cli.EXPECT().TraceNextQuery().Return(session[:], nil),
+		cli.EXPECT().BatchMutate(gomock.Any(), ConsistencyLevel_ONE),
+	)
+	n := newNode(localEndpoint, 0)
+	c := newTestConnection(n)
+	c.client = cli
+	n.available.Push(c)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+
+	var traced UUID
+	w := newWriter(cp, CONSISTENCY_ONE).Trace(&traced)
+	w.Insert("cf", &Row{Key: []byte("rowkey"), Columns: []*Column{&Column{Name: []byte("name"), Value: []byte("value")}}})
+	assert.NoError(t, w.RunContext(context.Background()))
+	assert.Equal(t, session, traced)
+}
+
+func TestTraceFromCql(t *testing.T) {
+	session, _ := ParseUUID("5d8ae3d0-2a79-11e3-8c0f-f3bbd8d4e2c5")
+	event, _ := ParseUUID("5d8b0ae0-2a79-11e3-8c0f-f3bbd8d4e2c5")
+	started, _ := Marshal(time.Unix(1380000000, 0), DateType)
+	row := &CqlRow{Columns: []*Column{
+		&Column{Name: []byte("session_id"), Value: session[:]},
+		&Column{Name: []byte("coordinator"), Value: []byte{10, 0, 0, 1}},
+		&Column{Name: []byte("request"), Value: []byte("execute_cql3_query")},
+		&Column{Name: []byte("parameters"), Value: []byte{0, 1, 0, 5, 'q', 'u', 'e', 'r', 'y', 0, 1, '*'}},
+		&Column{Name: []byte("started_at"), Value: started},
+		&Column{Name: []byte("duration")},
+	}}
+	events := []*CqlRow{&CqlRow{Columns: []*Column{
+		&Column{Name: []byte("event_id"), Value: event[:]},
+		&Column{Name: []byte("activity"), Value: []byte("Parsing statement")},
+		&Column{Name: []byte("source"), Value: []byte{10, 0, 0, 2}},
+		&Column{Name: []byte("source_elapsed"), Value: []byte{0, 0, 1, 0}},
+		&Column{Name: []byte("thread"), Value: []byte("Native-Transport-Requests:1")},
+	}}}
+
+	s, err := traceFromCql(row, events)
+	assert.NoError(t, err)
+	assert.Equal(t, &TraceSession{
+		ID:          session,
+		Coordinator: net.IP{10, 0, 0, 1},
+		Request:     "execute_cql3_query",
+		Parameters:  map[string]string{"query": "*"},
+		StartedAt:   time.Unix(1380000000, 0),
+		Events: []*TraceEvent{&TraceEvent{
+			ID:       event,
+			Activity: "Parsing statement",
+			Source:   net.IP{10, 0, 0, 2},
+			Elapsed:  256 * time.Microsecond,
+			Thread:   "Native-Transport-Requests:1",
+		}},
+	}, s)
+
+	row.Columns[3].Value = []byte{0, 1, 0, 5, 'q'}
+	_, err = traceFromCql(row, nil)
+	assert.Equal(t, ErrorCassandraTypeSerializationUnmarshaling, err)
+}
@@ -0,0 +1,188 @@
+package gossie
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	. "github.com/wadey/gossie/src/cassandra"
+)
+
+var ErrInvalidCursor = errors.New("Invalid range cursor")
+
+// RowIterator pages over a range of rows, see Reader.RangeIter
+type RowIterator interface {
+
+	// Next returns the next row of the range, reading a new page of rows when the current one is
+	// exhausted. It returns nil and Done when there are no more rows. Calling Next again after any
+	// other error retries reading the page that failed.
+	Next() (*Row, error)
+
+	// Cursor returns an opaque string with the position of the iterator, right after the last row
+	// returned by Next. Pass it in Range.Cursor to a new iterator to resume from there, even in
+	// another process.
+	Cursor() string
+}
+
+// rangeCursor is the position of a RowIterator, Cursor serializes it
+type rangeCursor struct {
+	Start      []byte `json:"s,omitempty"`  // key the range starts at
+	After      bool   `json:"a,omitempty"`  // Start was already returned, the range starts after it
+	StartToken string `json:"st,omitempty"` // token the range starts after, if Start is not set
+	End        []byte `json:"e,omitempty"`
+	EndToken   string `json:"et,omitempty"`
+	Done       bool   `json:"d,omitempty"` // there are no more rows
+}
+
+func parseRangeCursor(s string) (rangeCursor, error) {
+	var c rangeCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err = json.Unmarshal(b, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func (c *rangeCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// keyRange returns the key range to read the next page of count rows, one more if the first
+// one is Start and must be skipped
+func (c *rangeCursor) keyRange(count int, filter []*IndexExpression) *KeyRange {
+	kr := NewKeyRange()
+	if c.Start == nil && c.StartToken != "" {
+		startToken := c.StartToken
+		kr.StartToken = &startToken
+	} else {
+		kr.StartKey = c.Start
+		if kr.StartKey == nil {
+			kr.StartKey = []byte{}
+		}
+	}
+	if c.EndToken != "" {
+		endToken := c.EndToken
+		kr.EndToken = &endToken
+	} else {
+		kr.EndKey = c.End
+		if kr.EndKey == nil {
+			kr.EndKey = []byte{}
+		}
+	}
+	kr.Count = int32(count)
+	if c.After {
+		kr.Count++
+	}
+	if len(filter) != 0 {
+		kr.RowFilter = filter
+	}
+	return kr
+}
+
+type rowIterator struct {
+	reader *reader
+	ctx    context.Context
+	count  int
+	next   rangeCursor // position of the next page
+	pos    rangeCursor // position after the last row returned by Next
+	rows   []*Row      // rows of the current page not returned yet
+	err    error       // error returned by every call of Next
+}
+
+func (r *reader) RangeIter(rang *Range) RowIterator {
+	return r.RangeIterContext(context.Background(), rang)
+}
+
+func (r *reader) RangeIterContext(ctx context.Context, rang *Range) RowIterator {
+	if rang == nil {
+		rang = defaultRange
+	}
+	it := &rowIterator{reader: r, ctx: ctx, count: rang.Count}
+	if it.count <= 0 {
+		it.count = defaultRange.Count
+	}
+	switch {
+	case r.columnParent.ColumnFamily == "":
+		it.err = errors.New("No column family specified")
+	case rang.Cursor != "":
+		it.next, it.err = parseRangeCursor(rang.Cursor)
+	case len(rang.Start) == 0 && len(rang.End) == 0 && (r.startToken != "" || r.endToken != ""):
+		it.next = rangeCursor{StartToken: r.startToken, EndToken: r.endToken}
+		if it.next.StartToken == "" {
+			it.next.StartToken = DEF_START_TOKEN
+		}
+		if it.next.EndToken == "" {
+			it.next.EndToken = DEF_END_TOKEN
+		}
+	default:
+		it.next = rangeCursor{Start: rang.Start, End: rang.End}
+	}
+	it.pos = it.next
+	return it
+}
+
+func (it *rowIterator) Next() (*Row, error) {
+	for len(it.rows) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		if it.next.Done {
+			return nil, Done
+		}
+		if err := it.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	row := it.rows[0]
+	it.rows = it.rows[1:]
+	if len(it.rows) == 0 {
+		// the page may end with empty rows, resume after them
+		it.pos = it.next
+	} else {
+		it.pos = rangeCursor{Start: row.Key, After: true, End: it.next.End, EndToken: it.next.EndToken}
+	}
+	return row, nil
+}
+
+func (it *rowIterator) Cursor() string {
+	return it.pos.String()
+}
+
+// fetch reads the next page of rows
+func (it *rowIterator) fetch() (err error) {
+	r := it.reader
+	ctx, end := r.startSpan(it.ctx, "RowIterator.Next", 0)
+	defer func() { end(err) }()
+
+	kr := it.next.keyRange(it.count, r.expressions)
+	sp := r.buildPredicate()
+
+	var ksv []*KeySlice
+	err = r.pool.runOperation(ctx, r.operation(nil), func(c *connection) error {
+		var err error
+		ksv, err = c.client.GetRangeSlices(&r.columnParent, sp, kr, c.consistency)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	done := len(ksv) < int(kr.Count)
+	if it.next.After && len(ksv) > 0 && bytes.Equal(ksv[0].Key, it.next.Start) {
+		ksv = ksv[1:]
+	}
+	if len(ksv) > 0 {
+		it.next.Start = ksv[len(ksv)-1].Key
+		it.next.After = true
+		it.next.StartToken = ""
+	}
+	it.next.Done = done
+	it.rows = rowsFromTListKeySlice(ksv)
+	return nil
+}
@@ -0,0 +1,124 @@
+package gossie
+
+import (
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func testKeySlices(keys ...string) []*KeySlice {
+	ksv := make([]*KeySlice, 0, len(keys))
+	for _, key := range keys {
+		ksv = append(ksv, &KeySlice{Key: []byte(key), Columns: []*ColumnOrSuperColumn{
+			&ColumnOrSuperColumn{Column: &Column{Name: []byte("name"), Value: []byte(key)}},
+		}})
+	}
+	return ksv
+}
+
+func testKeyRange(start, end string, count int32) *KeyRange {
+	kr := NewKeyRange()
+	kr.StartKey, kr.EndKey, kr.Count = []byte(start), []byte(end), count
+	return kr
+}
+
+func testRangePool(cli Cassandra) *connectionPool {
+	n := newNode(localEndpoint, 0)
+	c := newTestConnection(n)
+	c.client = cli
+	n.available.Push(c)
+	return &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+}
+
+func iterKeys(it RowIterator) ([]string, error) {
+	var keys []string
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, string(row.Key))
+	}
+}
+
+func TestRangeIter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	sp := &SlicePredicate{SliceRange: fullSlice()}
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(cp, sp, testKeyRange("a", "z", 2), ConsistencyLevel_ONE).Return(testKeySlices("a", "b"), nil),
+		cli.EXPECT().GetRangeSlices(cp, sp, testKeyRange("b", "z", 3), ConsistencyLevel_ONE).Return(testKeySlices("b", "c", "d"), nil),
+		cli.EXPECT().GetRangeSlices(cp, sp, testKeyRange("d", "z", 3), ConsistencyLevel_ONE).Return(testKeySlices("d", "e"), nil),
+	)
+
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").RangeIter(&Range{Start: []byte("a"), End: []byte("z"), Count: 2})
+	keys, err := iterKeys(it)
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys)
+	row, err := it.Next()
+	assert.Nil(t, row)
+	assert.Equal(t, Done, err)
+
+	c, err := parseRangeCursor(it.Cursor())
+	assert.NoError(t, err)
+	assert.True(t, c.Done)
+}
+
+func TestRangeIterCursor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	sp := &SlicePredicate{SliceRange: fullSlice()}
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(cp, sp, testKeyRange("", "", 3), ConsistencyLevel_ONE).Return(testKeySlices("a", "b", "c"), nil),
+		cli.EXPECT().GetRangeSlices(cp, sp, testKeyRange("a", "", 4), ConsistencyLevel_ONE).Return(testKeySlices("a", "b"), nil),
+	)
+	pool := testRangePool(cli)
+
+	it := newReader(pool, CONSISTENCY_ONE).Cf("cf").RangeIter(&Range{Count: 3})
+	row, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), row.Key)
+	cursor := it.Cursor()
+
+	// a new iterator, possibly with another page size, resumes after the last returned row
+	it = newReader(pool, CONSISTENCY_ONE).Cf("cf").RangeIter(&Range{Cursor: cursor, Count: 3})
+	keys, err := iterKeys(it)
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []string{"b"}, keys)
+
+	it = newReader(pool, CONSISTENCY_ONE).Cf("cf").RangeIter(&Range{Cursor: "not a cursor"})
+	_, err = it.Next()
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestRangeIterTokenRange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "cf"}
+	sp := &SlicePredicate{SliceRange: fullSlice()}
+	filter := []*IndexExpression{&IndexExpression{ColumnName: []byte("name"), Op: IndexOperator_EQ, Value: []byte("b")}}
+
+	first := NewKeyRange()
+	start, end := "0", "100"
+	first.StartToken, first.EndToken, first.Count, first.RowFilter = &start, &end, 2, filter
+	second := NewKeyRange()
+	second.StartKey, second.EndToken, second.Count, second.RowFilter = []byte("b"), &end, 3, filter
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(cp, sp, first, ConsistencyLevel_ONE).Return(testKeySlices("a", "b"), nil),
+		cli.EXPECT().GetRangeSlices(cp, sp, second, ConsistencyLevel_ONE).Return(testKeySlices("b"), nil),
+	)
+
+	it := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("cf").SetTokenRange("0", "100").
+		Where([]byte("name"), EQ, []byte("b")).RangeIter(&Range{Count: 2})
+	keys, err := iterKeys(it)
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+}
@@ -2,16 +2,19 @@ package gossie
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"sync"
 
-	"github.com/golang/glog"
 	. "github.com/wadey/gossie/src/cassandra"
 )
 
-// Row is a Cassandra row, including its row key
+// Row is a Cassandra row, including its row key. The rows of a super column family hold
+// SuperColumns instead of Columns, unless they are read with Reader.SuperColumn.
 type Row struct {
-	Key     []byte
-	Columns []*Column
+	Key          []byte
+	Columns      []*Column
+	SuperColumns []*SuperColumn
 }
 
 // RowColumnCount stores the number of columns matched in a MultiCount reader
@@ -39,6 +42,10 @@ type Range struct {
 	Start []byte
 	End   []byte
 	Count int
+
+	// Cursor resumes a RangeIter from the RowIterator.Cursor of a previous one, Start and End are
+	// ignored. RangeGet does not use it.
+	Cursor string
 }
 
 // IndexedRange represents a range of rows to return for the IndexedGet method.
@@ -69,6 +76,10 @@ type Reader interface {
 	// It is optional, if left uncalled it will default to your connection pool options value.
 	ConsistencyLevel(ConsistencyLevel) Reader
 
+	// Trace makes Cassandra trace the requests sent by the calls of this reader, storing the
+	// session of the last one in session. Use ConnectionPool.QueryTrace to read the trace.
+	Trace(session *UUID) Reader
+
 	// Cf sets the column family name for the reader.
 	// This method must be always called.
 	Cf(cf string) Reader
@@ -80,6 +91,11 @@ type Reader interface {
 	// Columns optionally filters the returned columns to only the passed set of column names
 	Columns(columns [][]byte) Reader
 
+	// SuperColumn makes the reader return the subcolumns of the named super column, of a super
+	// column family, as the Columns of the rows. Slice and Columns then select subcolumns. Without
+	// it the rows hold the SuperColumns selected by Slice and Columns.
+	SuperColumn(name []byte) Reader
+
 	// Each call to this method adds a new comparison to be checked against the returned rows of
 	// IndexedGet
 	// All the comparisons are checked for every row. In the current Cassandra implementation at
@@ -89,24 +105,49 @@ type Reader interface {
 	// Get looks up a row with the given key and returns it, or nil in case it is not found
 	Get(key []byte) (*Row, error)
 
+	// GetContext is like Get but it gives up when ctx is done, returning ctx.Err()
+	GetContext(ctx context.Context, key []byte) (*Row, error)
+
 	// MultiGet performs a parallel Get operation for all the passed keys, and returns a slice of
 	// RowColumnCounts pointers to the gathered rows, which may be empty if none were found. It returns
 	// nil only on error conditions
 	MultiGet(keys [][]byte) ([]*Row, error)
 
+	// MultiGetContext is like MultiGet but it gives up when ctx is done, returning ctx.Err()
+	MultiGetContext(ctx context.Context, keys [][]byte) ([]*Row, error)
+
 	// Count looks up a row with the given key and returns the number of columns it has
 	Count(key []byte) (int, error)
 
+	// CountContext is like Count but it gives up when ctx is done, returning ctx.Err()
+	CountContext(ctx context.Context, key []byte) (int, error)
+
 	// MultiGet performs a parallel Count operation for all the passed keys, and returns a slice of Row
 	// pointers to the gathered rows, which may be empty if none were found. It returns nil only on
 	// error conditions
 	MultiCount(keys [][]byte) ([]*RowColumnCount, error)
 
+	// MultiCountContext is like MultiCount but it gives up when ctx is done, returning ctx.Err()
+	MultiCountContext(ctx context.Context, keys [][]byte) ([]*RowColumnCount, error)
+
 	// RangeGet performs a sequential Get operation for a range of rows. See the docs for Range for an
 	// explanation on how to page results. It returns a slice of Row pointers to the gathered rows, which
 	// may be empty if none were found. It returns nil only on error conditions
 	RangeGet(r *Range) ([]*Row, error)
 
+	// RangeGetContext is like RangeGet but it gives up when ctx is done, returning ctx.Err()
+	RangeGetContext(ctx context.Context, r *Range) ([]*Row, error)
+
+	// RangeIter returns an iterator over a range of rows that reads them in pages of r.Count rows,
+	// 100 if it is not set, matching the Where clauses if any. If r has no Start and End keys and
+	// SetTokenRange was called it iterates over the token range, to scan a part of a column family
+	// that uses the random partitioner. The iterator Cursor can be passed in r.Cursor to resume.
+	RangeIter(r *Range) RowIterator
+
+	// RangeIterContext is like RangeIter but reading a page gives up when ctx is done, returning
+	// ctx.Err() from RowIterator.Next
+	RangeIterContext(ctx context.Context, r *Range) RowIterator
+
 	// IndexedGet performs a sequential Get operation for a range of rows and returns only those that match
 	// the Where clauses. See the docs for Range for an explanation on how to page results. It returns a
 	// slice of Row pointers to the gathered rows, which may be empty if none were found. It returns nil only
@@ -122,15 +163,48 @@ type Reader interface {
 	//Default page size is 100 rows
 	SetTokenRangeCount(count int) Reader
 
-	// Scan a range This function will call the callback
-	// function with data read. Callback should return true to continue scanning or false to stop
+	// SetScanBuffer sets how many rows Scan and RangeScan read ahead of the caller, 0 by default
+	SetScanBuffer(rows int) Reader
+
+	// Scan reads the rows of the token range set with SetTokenRange, or the whole ring, page by
+	// page in a background goroutine that stops when ctx is done or the scanner is closed.
 	// Usage:
-	// pool.Reader().SetTokenRange("1234", "4567").RangeScan(func(func(r *Row) bool)
+	// s := pool.Reader().Cf("cf").SetTokenRange("1234", "4567").Scan(ctx)
+	// defer s.Close()
+	// for row, err := s.Next(); err == nil; row, err = s.Next() { ... }
+	Scan(ctx context.Context) RowScanner
+
+	// RangeScan is like Scan but it sends the rows to the data channel. The scan goroutine blocks
+	// until every row is read from data, an error is sent to err if the scan fails.
+	// Deprecated, please use Scan instead, which can be closed
 	RangeScan() (data <-chan *Row, err <-chan error)
 
+	// RangeScanContext is like RangeScan but the scan stops and both channels are closed when ctx
+	// is done, even if nobody is reading from them, after sending ctx.Err() to err
+	RangeScanContext(ctx context.Context) (data <-chan *Row, err <-chan error)
+
 	//WideRowScan performs sequential scan for a range of columns in a single row. It will call the callback
 	// function with data read. Callback should return true to continue scanning or false to stop
 	WideRowScan(key, startColumn []byte, batchSize int32, callback func(*Column) bool) error
+
+	// ColumnIter returns an iterator over the columns of a row within slice, read in pages of
+	// slice.Count columns, 100 if it is not set. It goes backwards from slice.Start to slice.End if
+	// slice.Reversed is set, and stops at slice.End if it is not empty. The slice set with Slice is
+	// not used. On a super column family it iterates over the subcolumns of the super column set
+	// with SuperColumn, without it Next returns ErrSuperColumnIter.
+	ColumnIter(key []byte, slice *Slice) ColumnIterator
+
+	// ColumnIterContext is like ColumnIter but reading a page gives up when ctx is done,
+	// returning ctx.Err() from ColumnIterator.Next
+	ColumnIterContext(ctx context.Context, key []byte, slice *Slice) ColumnIterator
+
+	// MultiColumnIter is like ColumnIter but it iterates over the columns of every key in turn,
+	// ColumnIterator.Key tells the row of each column
+	MultiColumnIter(keys [][]byte, slice *Slice) ColumnIterator
+
+	// MultiColumnIterContext is like MultiColumnIter but reading a page gives up when ctx is
+	// done, returning ctx.Err() from ColumnIterator.Next
+	MultiColumnIterContext(ctx context.Context, keys [][]byte, slice *Slice) ColumnIterator
 }
 
 var (
@@ -149,7 +223,9 @@ type reader struct {
 	startToken       string
 	endToken         string
 	tokenRangeCount  int
+	scanBuffer       int
 	columnParent     ColumnParent
+	trace            *traceSession
 }
 
 func newReader(cp *connectionPool, cl ConsistencyLevel) *reader {
@@ -174,11 +250,45 @@ func (r *reader) ConsistencyLevel(l ConsistencyLevel) Reader {
 	return r
 }
 
+// operation returns how the pool must run a read of the given row key, reads are idempotent
+func (r *reader) operation(key []byte) *operation {
+	return &operation{key: key, consistency: r.consistencyLevel, idempotent: true, trace: r.trace}
+}
+
+// startSpan starts tracing a reader operation on the given number of row keys
+func (r *reader) startSpan(ctx context.Context, name string, keys int) (context.Context, func(error)) {
+	return r.pool.startSpan(ctx, OperationInfo{
+		Name:         name,
+		ColumnFamily: r.columnParent.ColumnFamily,
+		Keys:         keys,
+		Consistency:  r.consistencyLevel,
+	})
+}
+
+// speculativeOperation is like operation but the read can be sent to a second node when the
+// first one is slow, see PoolOptions.SpeculativeDelay. The transaction must store its results
+// with connection.commit.
+func (r *reader) speculativeOperation(key []byte) *operation {
+	op := r.operation(key)
+	op.speculative = true
+	return op
+}
+
+func (r *reader) Trace(session *UUID) Reader {
+	r.trace = newTraceSession(session)
+	return r
+}
+
 func (r *reader) Cf(cf string) Reader {
 	r.columnParent.ColumnFamily = cf
 	return r
 }
 
+func (r *reader) SuperColumn(name []byte) Reader {
+	r.columnParent.SuperColumn = name
+	return r
+}
+
 func (r *reader) Slice(s *Slice) Reader {
 	r.slice = *s
 	r.setSlice = true
@@ -266,6 +376,13 @@ func (r *reader) buildIndexClause(ir *IndexedRange) *IndexClause {
 }
 
 func (r *reader) Get(key []byte) (*Row, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+func (r *reader) GetContext(ctx context.Context, key []byte) (row *Row, err error) {
+	ctx, end := r.startSpan(ctx, "Reader.Get", 1)
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return nil, errors.New("No column family specified")
 	}
@@ -273,9 +390,11 @@ func (r *reader) Get(key []byte) (*Row, error) {
 	sp := r.buildPredicate()
 
 	var ret []*ColumnOrSuperColumn
-	err := r.pool.run(func(c *connection) error {
-		var err error
-		ret, err = c.client.GetSlice(key, &r.columnParent, sp, r.consistencyLevel)
+	err = r.pool.runOperation(ctx, r.speculativeOperation(key), func(c *connection) error {
+		res, err := c.client.GetSlice(key, &r.columnParent, sp, c.consistency)
+		if err == nil {
+			c.commit(func() { ret = res })
+		}
 		return err
 	})
 
@@ -287,6 +406,13 @@ func (r *reader) Get(key []byte) (*Row, error) {
 }
 
 func (r *reader) Count(key []byte) (int, error) {
+	return r.CountContext(context.Background(), key)
+}
+
+func (r *reader) CountContext(ctx context.Context, key []byte) (count int, err error) {
+	ctx, end := r.startSpan(ctx, "Reader.Count", 1)
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return 0, errors.New("No column family specified")
 	}
@@ -294,9 +420,9 @@ func (r *reader) Count(key []byte) (int, error) {
 	sp := r.buildPredicate()
 
 	var ret int32
-	err := r.pool.run(func(c *connection) error {
+	err = r.pool.runOperation(ctx, r.operation(key), func(c *connection) error {
 		var err error
-		ret, err = c.client.GetCount(key, &r.columnParent, sp, r.consistencyLevel)
+		ret, err = c.client.GetCount(key, &r.columnParent, sp, c.consistency)
 		return err
 	})
 
@@ -308,6 +434,13 @@ func (r *reader) Count(key []byte) (int, error) {
 }
 
 func (r *reader) MultiGet(keys [][]byte) ([]*Row, error) {
+	return r.MultiGetContext(context.Background(), keys)
+}
+
+func (r *reader) MultiGetContext(ctx context.Context, keys [][]byte) (rows []*Row, err error) {
+	ctx, end := r.startSpan(ctx, "Reader.MultiGet", len(keys))
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return nil, errors.New("No column family specified")
 	}
@@ -318,10 +451,23 @@ func (r *reader) MultiGet(keys [][]byte) ([]*Row, error) {
 
 	sp := r.buildPredicate()
 
-	var ret map[string][]*ColumnOrSuperColumn
-	err := r.pool.run(func(c *connection) error {
-		var err error
-		ret, err = c.client.MultigetSlice(keys, &r.columnParent, sp, r.consistencyLevel)
+	// keys are sent to their replicas in parallel, one MultigetSlice per replica
+	ret := make(map[string][]*ColumnOrSuperColumn)
+	var m sync.Mutex
+	err = runParallel(r.pool.splitByReplica(keys), func(keys [][]byte) error {
+		var part map[string][]*ColumnOrSuperColumn
+		err := r.pool.runOperation(ctx, r.speculativeOperation(keys[0]), func(c *connection) error {
+			res, err := c.client.MultigetSlice(keys, &r.columnParent, sp, c.consistency)
+			if err == nil {
+				c.commit(func() { part = res })
+			}
+			return err
+		})
+		m.Lock()
+		for k, v := range part {
+			ret[k] = v
+		}
+		m.Unlock()
 		return err
 	})
 
@@ -333,6 +479,13 @@ func (r *reader) MultiGet(keys [][]byte) ([]*Row, error) {
 }
 
 func (r *reader) MultiCount(keys [][]byte) ([]*RowColumnCount, error) {
+	return r.MultiCountContext(context.Background(), keys)
+}
+
+func (r *reader) MultiCountContext(ctx context.Context, keys [][]byte) (counts []*RowColumnCount, err error) {
+	ctx, end := r.startSpan(ctx, "Reader.MultiCount", len(keys))
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return nil, errors.New("No column family specified")
 	}
@@ -343,10 +496,20 @@ func (r *reader) MultiCount(keys [][]byte) ([]*RowColumnCount, error) {
 
 	sp := r.buildPredicate()
 
-	var ret map[string]int32
-	err := r.pool.run(func(c *connection) error {
-		var err error
-		ret, err = c.client.MultigetCount(keys, &r.columnParent, sp, r.consistencyLevel)
+	ret := make(map[string]int32)
+	var m sync.Mutex
+	err = runParallel(r.pool.splitByReplica(keys), func(keys [][]byte) error {
+		var part map[string]int32
+		err := r.pool.runOperation(ctx, r.operation(keys[0]), func(c *connection) error {
+			var err error
+			part, err = c.client.MultigetCount(keys, &r.columnParent, sp, c.consistency)
+			return err
+		})
+		m.Lock()
+		for k, v := range part {
+			ret[k] = v
+		}
+		m.Unlock()
 		return err
 	})
 
@@ -360,6 +523,13 @@ func (r *reader) MultiCount(keys [][]byte) ([]*RowColumnCount, error) {
 var defaultRange = &Range{Start: []byte{}, End: []byte{}, Count: 100}
 
 func (r *reader) RangeGet(rang *Range) ([]*Row, error) {
+	return r.RangeGetContext(context.Background(), rang)
+}
+
+func (r *reader) RangeGetContext(ctx context.Context, rang *Range) (rows []*Row, err error) {
+	ctx, end := r.startSpan(ctx, "Reader.RangeGet", 0)
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return nil, errors.New("No column family specified")
 	}
@@ -376,9 +546,9 @@ func (r *reader) RangeGet(rang *Range) ([]*Row, error) {
 	sp := r.buildPredicate()
 
 	var ret []*KeySlice
-	err := r.pool.run(func(c *connection) error {
+	err = r.pool.runOperation(ctx, r.operation(nil), func(c *connection) error {
 		var err error
-		ret, err = c.client.GetRangeSlices(&r.columnParent, sp, kr, r.consistencyLevel)
+		ret, err = c.client.GetRangeSlices(&r.columnParent, sp, kr, c.consistency)
 		return err
 	})
 
@@ -389,7 +559,10 @@ func (r *reader) RangeGet(rang *Range) ([]*Row, error) {
 	return rowsFromTListKeySlice(ret), nil
 }
 
-func (r *reader) IndexedGet(rang *IndexedRange) ([]*Row, error) {
+func (r *reader) IndexedGet(rang *IndexedRange) (rows []*Row, err error) {
+	ctx, end := r.startSpan(context.Background(), "Reader.IndexedGet", 0)
+	defer func() { end(err) }()
+
 	if r.columnParent.ColumnFamily == "" {
 		return nil, errors.New("No column family specified")
 	}
@@ -406,9 +579,9 @@ func (r *reader) IndexedGet(rang *IndexedRange) ([]*Row, error) {
 	sp := r.buildPredicate()
 
 	var ret []*KeySlice
-	err := r.pool.run(func(c *connection) error {
+	err = r.pool.runOperation(ctx, r.operation(nil), func(c *connection) error {
 		var err error
-		ret, err = c.client.GetIndexedSlices(&r.columnParent, ic, sp, r.consistencyLevel)
+		ret, err = c.client.GetIndexedSlices(&r.columnParent, ic, sp, c.consistency)
 		return err
 	})
 
@@ -420,78 +593,38 @@ func (r *reader) IndexedGet(rang *IndexedRange) ([]*Row, error) {
 }
 
 func (r *reader) RangeScan() (<-chan *Row, <-chan error) {
+	return r.RangeScanContext(context.Background())
+}
+
+func (r *reader) RangeScanContext(ctx context.Context) (<-chan *Row, <-chan error) {
 	if r.columnParent.ColumnFamily == "" {
 		panic(errors.New("No column family specified"))
 	}
-	kr := NewKeyRange()
-	if len(r.startToken) != 0 {
-		kr.StartToken = &r.startToken
-	} else {
-		kr.StartToken = &DEF_START_TOKEN
-	}
-	if len(r.endToken) != 0 {
-		kr.EndToken = &r.endToken
-	} else {
-		kr.EndToken = &DEF_END_TOKEN
-	}
-	if len(r.expressions) != 0 {
-		kr.RowFilter = r.expressions
-	}
-	if r.tokenRangeCount > 0 {
-		kr.Count = int32(r.tokenRangeCount)
-	}
-	sp := r.buildPredicate()
-
-	data := make(chan *Row)
-	rerr := make(chan error)
+	data := make(chan *Row, r.scanBuffer)
+	// buffered so the goroutine does not block when nobody reads the error
+	rerr := make(chan error, 1)
 
 	go func() {
 		defer close(rerr)
 		defer close(data)
-
-		for {
-			var ksv []*KeySlice
-			err := r.pool.run(func(c *connection) error {
-				var err error
-				ksv, err = c.client.GetRangeSlices(&r.columnParent, sp, kr, r.consistencyLevel)
-				return err
-			})
-
-			if err != nil {
-				glog.Error("Error in GetRangeSlices ", err)
-				rerr <- err
-				return
-			}
-			glog.V(2).Infof("Key slice vector size ", len(ksv))
-			if len(ksv) == 0 {
-				//phew. done
-				return
-			}
-			if kr.StartKey != nil && bytes.Equal(ksv[0].Key, kr.StartKey) {
-				//AP: I'm sending a diarrhea beam your way, dear designer of cassandra iteration
-				ksv = ksv[1:]
-			}
-			if len(ksv) == 0 {
-				//phew. done
-				return
-			}
-			kr.StartToken = nil
-			kr.StartKey = ksv[len(ksv)-1].Key //just in case it is mutable
-			glog.V(2).Infof("Next batch starts with %q", kr.StartKey)
-			for _, ks := range ksv {
-				glog.V(2).Infof("Raw row key %s columns %v", string(ks.Key), ks.Columns)
-				row := rowFromTListColumns(ks.Key, ks.Columns)
-				glog.V(2).Infof("Row %q", row)
-				if row != nil {
-					data <- row
-				}
-			}
+		ctx, end := r.startSpan(ctx, "Reader.RangeScan", 0)
+		err := r.scan(ctx, r.tokenKeyRange(), nil, sendRows(ctx, data))
+		end(err)
+		if err != nil && ctx.Err() != nil {
+			// stopped by ctx, whatever the error of the read it interrupted
+			err = ctx.Err()
+		}
+		if err != nil {
+			rerr <- err
 		}
 	}()
 	return data, rerr
 }
 
-func (r *reader) WideRowScan(key, startColumn []byte, batchSize int32, callback func(*Column) bool) error {
+func (r *reader) WideRowScan(key, startColumn []byte, batchSize int32, callback func(*Column) bool) (err error) {
+	ctx, end := r.startSpan(context.Background(), "Reader.WideRowScan", 1)
+	defer func() { end(err) }()
+
 	keyRange := NewKeyRange()
 	keyRange.StartKey = key
 	keyRange.EndKey = key
@@ -499,9 +632,9 @@ func (r *reader) WideRowScan(key, startColumn []byte, batchSize int32, callback
 
 	var ret []*KeySlice
 	for {
-		err := r.pool.run(func(c *connection) error {
+		err = r.pool.runOperation(ctx, r.operation(key), func(c *connection) error {
 			var err error
-			ret, err = c.client.GetPagedSlice(r.columnParent.ColumnFamily, keyRange, startColumn, r.consistencyLevel)
+			ret, err = c.client.GetPagedSlice(r.columnParent.ColumnFamily, keyRange, startColumn, c.consistency)
 			return err
 		})
 
@@ -539,22 +672,39 @@ func rowFromTListColumns(key []byte, tl []*ColumnOrSuperColumn) *Row {
 	if len(tl) == 0 {
 		return r
 	}
-	r.Columns = make([]*Column, 0, len(tl))
+	if tl[0].SuperColumn == nil && tl[0].CounterSuperColumn == nil {
+		r.Columns = make([]*Column, 0, len(tl))
+	}
 	for _, col := range tl {
 		if col.Column != nil {
 			r.Columns = append(r.Columns, col.Column)
 		} else if col.CounterColumn != nil {
-			v, _ := Marshal(col.CounterColumn.Value, LongType)
-			c := &Column{
-				Name:  col.CounterColumn.Name,
-				Value: v,
+			r.Columns = append(r.Columns, columnFromCounter(col.CounterColumn))
+		} else if col.SuperColumn != nil {
+			r.SuperColumns = append(r.SuperColumns, col.SuperColumn)
+		} else if col.CounterSuperColumn != nil {
+			sc := &SuperColumn{
+				Name:    col.CounterSuperColumn.Name,
+				Columns: make([]*Column, 0, len(col.CounterSuperColumn.Columns)),
+			}
+			for _, cc := range col.CounterSuperColumn.Columns {
+				sc.Columns = append(sc.Columns, columnFromCounter(cc))
 			}
-			r.Columns = append(r.Columns, c)
+			r.SuperColumns = append(r.SuperColumns, sc)
 		}
 	}
 	return r
 }
 
+// columnFromCounter returns the counter as a column with its value marshaled as a LongType
+func columnFromCounter(cc *CounterColumn) *Column {
+	v, _ := Marshal(cc.Value, LongType)
+	return &Column{
+		Name:  cc.Name,
+		Value: v,
+	}
+}
+
 func rowsFromTMap(tm map[string][]*ColumnOrSuperColumn) []*Row {
 	if tm == nil || len(tm) <= 0 {
 		return nil
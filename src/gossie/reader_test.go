@@ -0,0 +1,38 @@
+package gossie
+
+import (
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func TestRowFromTListSuperColumns(t *testing.T) {
+	sc := &SuperColumn{Name: []byte("sc1"), Columns: []*Column{&Column{Name: []byte("name"), Value: []byte("value")}}}
+	row := rowFromTListColumns([]byte("key"), []*ColumnOrSuperColumn{
+		&ColumnOrSuperColumn{SuperColumn: sc},
+		&ColumnOrSuperColumn{CounterSuperColumn: &CounterSuperColumn{Name: []byte("sc2"), Columns: []*CounterColumn{
+			&CounterColumn{Name: []byte("count"), Value: 3},
+		}}},
+	})
+	assert.Nil(t, row.Columns)
+	assert.Equal(t, []*SuperColumn{
+		sc,
+		&SuperColumn{Name: []byte("sc2"), Columns: []*Column{&Column{Name: []byte("count"), Value: []byte{0, 0, 0, 0, 0, 0, 0, 3}}}},
+	}, row.SuperColumns)
+}
+
+func TestReaderSuperColumn(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cp := &ColumnParent{ColumnFamily: "super", SuperColumn: []byte("sc")}
+	cli.EXPECT().GetSlice([]byte("key"), cp, gomock.Any(), ConsistencyLevel_ONE).Return(testColumns("a", "b"), nil)
+
+	row, err := newReader(testRangePool(cli), CONSISTENCY_ONE).Cf("super").SuperColumn([]byte("sc")).Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, row.ColumnNames())
+	assert.Nil(t, row.SuperColumns)
+}
@@ -0,0 +1,174 @@
+package gossie
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// replaced in tests
+var (
+	lookupHost = net.LookupHost
+	lookupSRV  = net.LookupSRV
+)
+
+// seedAddr is a node address resolved from a seed
+type seedAddr struct {
+	addr string // "ip:port", or the seed itself if it could not be resolved
+	name string // host name it was resolved from, empty if the seed is an IP address
+}
+
+// resolveSeed expands a "host:port" seed to one address per A record of the host, or per AAAA
+// record if it has no A records. It returns the host name, or an empty one if the host is an IP
+// address, which is never resolved.
+func resolveSeed(seed string) (addrs []string, name string, err error) {
+	host, port, err := net.SplitHostPort(seed)
+	if err != nil {
+		return nil, "", err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{seed}, "", nil
+	}
+	ips, err := lookupHost(host)
+	if err != nil {
+		return nil, host, err
+	}
+	var v6 []string
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+			v6 = append(v6, net.JoinHostPort(ip, port))
+		} else {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+	if len(addrs) == 0 {
+		addrs = v6
+	}
+	return addrs, host, nil
+}
+
+// srvSeeds returns the "host:port" targets of the SRV records of name
+func srvSeeds(name string) ([]string, error) {
+	_, records, err := lookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	seeds := make([]string, 0, len(records))
+	for _, r := range records {
+		seeds = append(seeds, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+	}
+	return seeds, nil
+}
+
+// resolveSeeds expands the seeds, and the targets of the SRV records of PoolOptions.SRVName, to
+// the addresses of the nodes. Seeds that cannot be resolved are kept as they are, so they are
+// resolved again when connecting and by the next re-resolution.
+func (cp *connectionPool) resolveSeeds(seeds []string) []seedAddr {
+	if cp.options.SRVName != "" {
+		targets, err := srvSeeds(cp.options.SRVName)
+		if err != nil {
+			cp.options.Logger.Warn("Cannot read the SRV records", "name", cp.options.SRVName, "error", err)
+		}
+		seeds = append(seeds[:len(seeds):len(seeds)], targets...)
+	}
+	var resolved []seedAddr
+	seen := make(map[string]bool)
+	for _, seed := range seeds {
+		addrs, name, err := resolveSeed(seed)
+		if err != nil {
+			cp.options.Logger.Warn("Cannot resolve node", "node", seed, "error", err)
+			addrs = []string{seed}
+		}
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				resolved = append(resolved, seedAddr{addr: addr, name: name})
+			}
+		}
+	}
+	return resolved
+}
+
+// resolver resolves the seeds of the cluster again every d, see reresolve
+func (cp *connectionPool) resolver(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cp.cluster.done:
+			return
+		case <-ticker.C:
+		}
+		cp.reresolve(cp.cluster.getSeeds())
+	}
+}
+
+// reresolve resolves the seeds again, and the SRV records of PoolOptions.SRVName, adding the
+// nodes of the new addresses of a host and removing the ones of its addresses that are gone.
+// The nodes of a host that cannot be resolved are kept, as well as the nodes that were not
+// resolved from a host name, like the discovered ones. If the SRV records cannot be read the
+// seeds are still resolved, and the nodes of the hosts that are not seeds are kept.
+func (cp *connectionPool) reresolve(seeds []string) {
+	srvFailed := false
+	if cp.options.SRVName != "" {
+		targets, err := srvSeeds(cp.options.SRVName)
+		if err != nil {
+			cp.options.Logger.Warn("Cannot read the SRV records", "name", cp.options.SRVName, "error", err)
+			srvFailed = true
+		}
+		seeds = append(seeds[:len(seeds):len(seeds)], targets...)
+	}
+
+	// addresses of every host that could be resolved
+	wanted := make(map[string]map[string]bool)
+	failed := make(map[string]bool)
+	for _, seed := range seeds {
+		addrs, name, err := resolveSeed(seed)
+		if name == "" {
+			continue
+		}
+		if err != nil {
+			cp.options.Logger.Warn("Cannot resolve node", "node", seed, "error", err)
+			failed[name] = true
+			continue
+		}
+		if wanted[name] == nil {
+			wanted[name] = make(map[string]bool)
+		}
+		for _, addr := range addrs {
+			wanted[name][addr] = true
+		}
+	}
+
+	nodes, _ := cp.cluster.get()
+	for _, n := range nodes {
+		if n.name == "" || failed[n.name] || wanted[n.name][n.node] || (srvFailed && wanted[n.name] == nil) {
+			continue
+		}
+		// the address is gone, or the host left the SRV records
+		cp.options.Logger.Info("Node address is gone, removing it", "node", n.node, "host", n.name)
+		if removed := cp.cluster.remove(n.node); removed != nil {
+			removed.remove()
+		}
+	}
+
+	// after the removals, so an address that moved to another host is added again right away
+	nodes, _ = cp.cluster.get()
+	present := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		present[n.node] = true
+	}
+	for name, addrs := range wanted {
+		for addr := range addrs {
+			if present[addr] {
+				continue
+			}
+			n := newNode(addr, cp.options.MaxConnections)
+			n.name = name
+			if cp.cluster.add(n) {
+				cp.options.Logger.Info("New node address, adding it", "node", addr, "host", name)
+			}
+		}
+	}
+}
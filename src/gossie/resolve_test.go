@@ -0,0 +1,134 @@
+package gossie
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeDNS(hosts map[string][]string, srv []*net.SRV) func() {
+	lookupHost = func(host string) ([]string, error) {
+		if addrs, ok := hosts[host]; ok {
+			return addrs, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if srv == nil {
+			return "", nil, errors.New("no such host")
+		}
+		return name, srv, nil
+	}
+	return func() { lookupHost, lookupSRV = net.LookupHost, net.LookupSRV }
+}
+
+func TestResolveSeed(t *testing.T) {
+	defer fakeDNS(map[string][]string{
+		"cass.example.com": {"10.0.0.1", "fd00::1", "10.0.0.2"},
+		"v6.example.com":   {"fd00::2"},
+	}, nil)()
+
+	addrs, name, err := resolveSeed("cass.example.com:9160")
+	assert.NoError(t, err)
+	assert.Equal(t, "cass.example.com", name)
+	assert.Equal(t, []string{"10.0.0.1:9160", "10.0.0.2:9160"}, addrs)
+
+	addrs, _, _ = resolveSeed("v6.example.com:9160")
+	assert.Equal(t, []string{"[fd00::2]:9160"}, addrs)
+
+	addrs, name, err = resolveSeed("10.0.0.3:9160")
+	assert.NoError(t, err)
+	assert.Equal(t, "", name)
+	assert.Equal(t, []string{"10.0.0.3:9160"}, addrs)
+
+	_, name, err = resolveSeed("gone.example.com:9160")
+	assert.Error(t, err)
+	assert.Equal(t, "gone.example.com", name)
+}
+
+func TestResolveSeeds(t *testing.T) {
+	defer fakeDNS(map[string][]string{
+		"cass.example.com": {"10.0.0.1", "10.0.0.2"},
+		"srv1.example.com": {"10.0.0.2"},
+		"srv2.example.com": {"10.0.0.4"},
+	}, []*net.SRV{
+		{Target: "srv1.example.com.", Port: 9160},
+		{Target: "srv2.example.com.", Port: 9161},
+	})()
+	cp := &connectionPool{options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+	cp.options.SRVName = "_cassandra._tcp.example.com"
+
+	assert.Equal(t, []seedAddr{
+		{"10.0.0.1:9160", "cass.example.com"},
+		{"10.0.0.2:9160", "cass.example.com"},
+		{"gone.example.com:9160", "gone.example.com"},
+		{"10.0.0.4:9161", "srv2.example.com"},
+	}, cp.resolveSeeds([]string{"cass.example.com:9160", "gone.example.com:9160"}))
+}
+
+func TestReresolve(t *testing.T) {
+	hosts := map[string][]string{"cass.example.com": {"10.0.0.1", "10.0.0.2"}}
+	defer fakeDNS(hosts, nil)()
+	seeds := []string{"cass.example.com:9160", "gone.example.com:9160"}
+	n1 := newNode("10.0.0.1:9160", 0)
+	n1.name = "cass.example.com"
+	n2 := newNode("10.0.0.2:9160", 0)
+	n2.name = "cass.example.com"
+	n3 := newNode("gone.example.com:9160", 0)
+	n3.name = "gone.example.com"
+	discovered := newNode("10.0.0.9:9160", 0)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2, n3, discovered}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	// the host changed one of its addresses, the other host still cannot be resolved
+	hosts["cass.example.com"] = []string{"10.0.0.2", "10.0.0.3"}
+	cp.reresolve(seeds)
+	assert.True(t, n1.isRemoved())
+	var addrs []string
+	for _, n := range cp.Nodes() {
+		addrs = append(addrs, n.Addr)
+	}
+	assert.Equal(t, []string{"10.0.0.2:9160", "10.0.0.3:9160", "10.0.0.9:9160", "gone.example.com:9160"}, addrs)
+
+	// the unresolved seed is replaced by its addresses once it resolves
+	hosts["gone.example.com"] = []string{"10.0.0.5"}
+	cp.reresolve(seeds)
+	assert.True(t, n3.isRemoved())
+	assert.Equal(t, "gone.example.com", cp.cluster.find("10.0.0.5:9160").name)
+}
+
+func TestReresolveMovedAddress(t *testing.T) {
+	hosts := map[string][]string{"a.example.com": {"10.0.0.2"}, "b.example.com": {"10.0.0.1"}}
+	defer fakeDNS(hosts, nil)()
+	n := newNode("10.0.0.1:9160", 0)
+	n.name = "a.example.com"
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+
+	// the address moved from a host to the other one
+	cp.reresolve([]string{"a.example.com:9160", "b.example.com:9160"})
+	assert.True(t, n.isRemoved())
+	assert.Equal(t, "b.example.com", cp.cluster.find("10.0.0.1:9160").name)
+	assert.Equal(t, "a.example.com", cp.cluster.find("10.0.0.2:9160").name)
+}
+
+func TestReresolveSRVError(t *testing.T) {
+	hosts := map[string][]string{"cass.example.com": {"10.0.0.2"}}
+	defer fakeDNS(hosts, nil)()
+	n1 := newNode("10.0.0.1:9160", 0)
+	n1.name = "cass.example.com"
+	srv := newNode("10.0.0.4:9160", 0)
+	srv.name = "srv.example.com"
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, srv}}, options: DefaultPoolOptions}
+	cp.options.Logger = &testLogger{}
+	cp.options.SRVName = "_cassandra._tcp.example.com"
+
+	// the seeds are resolved even if the SRV records cannot be read, whose nodes are kept
+	cp.reresolve([]string{"cass.example.com:9160"})
+	assert.True(t, n1.isRemoved())
+	assert.False(t, srv.isRemoved())
+	assert.NotNil(t, cp.cluster.find("10.0.0.2:9160"))
+}
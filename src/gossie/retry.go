@@ -0,0 +1,167 @@
+package gossie
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+// FailureType classifies the errors of a failed transaction attempt
+type FailureType int
+
+const (
+	// FailureTimedOut means the coordinator node timed out waiting for the replicas, the
+	// operation may or may not have been applied
+	FailureTimedOut FailureType = iota
+
+	// FailureUnavailable means there were not enough live replicas to satisfy the consistency
+	// level, the operation was not applied
+	FailureUnavailable
+
+	// FailureTransport means the connection failed or any other unexpected error was returned,
+	// the operation may or may not have been applied
+	FailureTransport
+)
+
+// RetryDecision is what a RetryPolicy decides to do after a failed attempt
+type RetryDecision int
+
+const (
+	// Rethrow gives up and returns the error of the attempt
+	Rethrow RetryDecision = iota
+
+	// Retry tries again at the same consistency level, with the next node of the query plan
+	Retry
+
+	// RetryLowerConsistency tries again with the next node of the query plan at the next lower
+	// consistency level, see LowerConsistency
+	RetryLowerConsistency
+)
+
+// RetryInfo describes a failed attempt of a transaction
+type RetryInfo struct {
+	Attempt     int                        // number of the failed attempt, starting at 1
+	Err         error                      // error returned by the attempt
+	Failure     FailureType                // kind of error returned by the attempt
+	Idempotent  bool                       // the operation can be safely applied more than once
+	Consistency cassandra.ConsistencyLevel // consistency level used by the attempt
+}
+
+// RetryPolicy decides if and when a failed transaction is tried again. The pool never makes
+// more than PoolOptions.Retries attempts, whatever the policy decides, and errors that cannot be
+// fixed by retrying, like InvalidRequestException, are returned without asking the policy.
+type RetryPolicy interface {
+	// OnFailure returns what to do after a failed attempt and how long to wait before the next one
+	OnFailure(info RetryInfo) (RetryDecision, time.Duration)
+}
+
+// NewSimpleRetryPolicy returns a RetryPolicy that retries right away after any failure
+func NewSimpleRetryPolicy() RetryPolicy {
+	return simpleRetryPolicy{}
+}
+
+type simpleRetryPolicy struct{}
+
+func (simpleRetryPolicy) OnFailure(info RetryInfo) (RetryDecision, time.Duration) {
+	return Retry, 0
+}
+
+// NewExponentialBackoffPolicy returns a RetryPolicy that waits a random time between 0 and
+// base * 2^(attempt-1), capped to max, before every retry. Operations that are not idempotent
+// are only retried when they are known not to have been applied (FailureUnavailable).
+func NewExponentialBackoffPolicy(base, max time.Duration) RetryPolicy {
+	return &exponentialBackoffPolicy{base: base, max: max}
+}
+
+type exponentialBackoffPolicy struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func (p *exponentialBackoffPolicy) OnFailure(info RetryInfo) (RetryDecision, time.Duration) {
+	if !info.Idempotent && info.Failure != FailureUnavailable {
+		return Rethrow, 0
+	}
+	return Retry, p.backoff(info.Attempt)
+}
+
+func (p *exponentialBackoffPolicy) backoff(attempt int) time.Duration {
+	d := p.max
+	if attempt < 1 {
+		attempt = 1
+	}
+	// stop doubling before overflowing
+	if attempt <= 32 {
+		if b := p.base << uint(attempt-1); b > 0 && b < p.max {
+			d = b
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// NewDowngradingConsistencyPolicy returns a RetryPolicy that follows the decisions of policy,
+// but lowers the consistency level when it retries an attempt that failed because of
+// FailureUnavailable or FailureTimedOut. This trades consistency for availability, only use it
+// when reading or writing at a lower consistency level is acceptable.
+func NewDowngradingConsistencyPolicy(policy RetryPolicy) RetryPolicy {
+	return &downgradingConsistencyPolicy{policy: policy}
+}
+
+type downgradingConsistencyPolicy struct {
+	policy RetryPolicy
+}
+
+func (p *downgradingConsistencyPolicy) OnFailure(info RetryInfo) (RetryDecision, time.Duration) {
+	decision, delay := p.policy.OnFailure(info)
+	if decision == Retry && info.Failure != FailureTransport {
+		decision = RetryLowerConsistency
+	}
+	return decision, delay
+}
+
+// LowerConsistency returns the consistency level a RetryLowerConsistency decision switches to.
+// ONE, ANY and unknown levels are returned unchanged.
+func LowerConsistency(l cassandra.ConsistencyLevel) cassandra.ConsistencyLevel {
+	switch l {
+	case CONSISTENCY_ALL:
+		return CONSISTENCY_QUORUM
+	case CONSISTENCY_EACH_QUORUM:
+		return CONSISTENCY_LOCAL_QUORUM
+	case CONSISTENCY_THREE:
+		return CONSISTENCY_TWO
+	case CONSISTENCY_QUORUM, CONSISTENCY_LOCAL_QUORUM, CONSISTENCY_TWO:
+		return CONSISTENCY_ONE
+	}
+	return l
+}
+
+// failureType classifies the error of a failed attempt
+func failureType(err error) FailureType {
+	switch err.(type) {
+	case *cassandra.TimedOutException:
+		return FailureTimedOut
+	case *cassandra.UnavailableException:
+		return FailureUnavailable
+	}
+	return FailureTransport
+}
+
+// sleep waits for d or until ctx is done, returning ctx.Err() in that case
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,106 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+func TestExponentialBackoffPolicy(t *testing.T) {
+	p := NewExponentialBackoffPolicy(10*time.Millisecond, time.Second)
+
+	for attempt := 1; attempt <= 100; attempt++ {
+		decision, delay := p.OnFailure(RetryInfo{Attempt: attempt, Failure: FailureTimedOut, Idempotent: true})
+		assert.Equal(t, Retry, decision)
+		assert.True(t, delay >= 0)
+		if attempt == 1 {
+			assert.True(t, delay < 10*time.Millisecond)
+		}
+		assert.True(t, delay < time.Second)
+	}
+
+	decision, _ := p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureTimedOut})
+	assert.Equal(t, Rethrow, decision)
+	decision, _ = p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureTransport})
+	assert.Equal(t, Rethrow, decision)
+	decision, _ = p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureUnavailable})
+	assert.Equal(t, Retry, decision)
+}
+
+func TestDowngradingConsistencyPolicy(t *testing.T) {
+	p := NewDowngradingConsistencyPolicy(NewSimpleRetryPolicy())
+
+	decision, delay := p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureUnavailable})
+	assert.Equal(t, RetryLowerConsistency, decision)
+	assert.Equal(t, time.Duration(0), delay)
+	decision, _ = p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureTimedOut})
+	assert.Equal(t, RetryLowerConsistency, decision)
+	decision, _ = p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureTransport})
+	assert.Equal(t, Retry, decision)
+
+	p = NewDowngradingConsistencyPolicy(NewExponentialBackoffPolicy(time.Millisecond, time.Millisecond))
+	decision, _ = p.OnFailure(RetryInfo{Attempt: 1, Failure: FailureTimedOut})
+	assert.Equal(t, Rethrow, decision)
+}
+
+func TestLowerConsistency(t *testing.T) {
+	assert.Equal(t, CONSISTENCY_QUORUM, LowerConsistency(CONSISTENCY_ALL))
+	assert.Equal(t, CONSISTENCY_ONE, LowerConsistency(CONSISTENCY_QUORUM))
+	assert.Equal(t, CONSISTENCY_LOCAL_QUORUM, LowerConsistency(CONSISTENCY_EACH_QUORUM))
+	assert.Equal(t, CONSISTENCY_ONE, LowerConsistency(CONSISTENCY_LOCAL_QUORUM))
+	assert.Equal(t, CONSISTENCY_TWO, LowerConsistency(CONSISTENCY_THREE))
+	assert.Equal(t, CONSISTENCY_ONE, LowerConsistency(CONSISTENCY_TWO))
+	assert.Equal(t, CONSISTENCY_ONE, LowerConsistency(CONSISTENCY_ONE))
+	assert.Equal(t, CONSISTENCY_ANY, LowerConsistency(CONSISTENCY_ANY))
+}
+
+func TestFailureType(t *testing.T) {
+	assert.Equal(t, FailureTimedOut, failureType(cassandra.NewTimedOutException()))
+	assert.Equal(t, FailureUnavailable, failureType(cassandra.NewUnavailableException()))
+	assert.Equal(t, FailureTransport, failureType(errors.New("broken pipe")))
+}
+
+func TestRunOperationRetryPolicy(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	for i := 0; i < 3; i++ {
+		n.available.Push(newTestConnection(n))
+	}
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: DefaultPoolOptions}
+	cp.options.RetryPolicy = NewDowngradingConsistencyPolicy(NewSimpleRetryPolicy())
+
+	var levels []cassandra.ConsistencyLevel
+	err := cp.runOperation(context.Background(), &operation{consistency: CONSISTENCY_ALL, idempotent: true}, func(c *connection) error {
+		levels = append(levels, c.consistency)
+		if len(levels) < 3 {
+			return cassandra.NewUnavailableException()
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []cassandra.ConsistencyLevel{CONSISTENCY_ALL, CONSISTENCY_QUORUM, CONSISTENCY_ONE}, levels)
+
+	cp.options.RetryPolicy = NewExponentialBackoffPolicy(time.Millisecond, time.Millisecond)
+	unavailable := cassandra.NewUnavailableException()
+	attempts := 0
+	err = cp.runOperation(context.Background(), &operation{retries: 2}, func(c *connection) error {
+		attempts++
+		return unavailable
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 2, attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cp.options.RetryPolicy = NewExponentialBackoffPolicy(time.Hour, time.Hour)
+	attempts = 0
+	err = cp.runOperation(ctx, &operation{}, func(c *connection) error {
+		attempts++
+		cancel()
+		return unavailable
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,368 @@
+package gossie
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+/*
+   to do:
+   OrderPreservingPartitioner and CollatingOrderPreservingPartitioner
+*/
+
+// token is a position in the Cassandra token ring
+type token interface {
+	less(token) bool
+}
+
+// partitioner hashes row keys into tokens the same way the server does
+type partitioner interface {
+	hash(key []byte) token
+	parse(s string) (token, error)
+}
+
+// newPartitioner returns the partitioner for the class name reported by DescribePartitioner,
+// or nil if it is not supported
+func newPartitioner(class string) partitioner {
+	switch class[strings.LastIndex(class, ".")+1:] {
+	case "Murmur3Partitioner":
+		return murmur3Partitioner{}
+	case "RandomPartitioner":
+		return randomPartitioner{}
+	case "ByteOrderedPartitioner":
+		return byteOrderedPartitioner{}
+	}
+	return nil
+}
+
+type murmur3Token int64
+
+func (t murmur3Token) less(o token) bool {
+	return t < o.(murmur3Token)
+}
+
+type murmur3Partitioner struct{}
+
+func (murmur3Partitioner) hash(key []byte) token {
+	h := murmur3H1(key)
+	// Long.MIN_VALUE is reserved by the server as the minimum token
+	if h == -1<<63 {
+		h = 1<<63 - 1
+	}
+	return murmur3Token(h)
+}
+
+func (murmur3Partitioner) parse(s string) (token, error) {
+	t, err := strconv.ParseInt(s, 10, 64)
+	return murmur3Token(t), err
+}
+
+type randomToken struct {
+	*big.Int
+}
+
+func (t randomToken) less(o token) bool {
+	return t.Cmp(o.(randomToken).Int) < 0
+}
+
+type randomPartitioner struct{}
+
+func (randomPartitioner) hash(key []byte) token {
+	sum := md5.Sum(key)
+	// the server reads the digest as a signed two's complement integer and takes its absolute value
+	t := new(big.Int).SetBytes(sum[:])
+	if sum[0]&0x80 != 0 {
+		t.Sub(t, new(big.Int).Lsh(big.NewInt(1), 128))
+	}
+	return randomToken{t.Abs(t)}
+}
+
+func (randomPartitioner) parse(s string) (token, error) {
+	t, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("Invalid RandomPartitioner token %q", s)
+	}
+	return randomToken{t}, nil
+}
+
+type byteOrderedToken []byte
+
+func (t byteOrderedToken) less(o token) bool {
+	return bytes.Compare(t, o.(byteOrderedToken)) < 0
+}
+
+type byteOrderedPartitioner struct{}
+
+func (byteOrderedPartitioner) hash(key []byte) token {
+	return byteOrderedToken(key)
+}
+
+func (byteOrderedPartitioner) parse(s string) (token, error) {
+	t, err := hex.DecodeString(s)
+	return byteOrderedToken(t), err
+}
+
+// tokenRing maps tokens to the pool nodes that are replicas for them
+type tokenRing struct {
+	partitioner partitioner
+	// tokens holds the sorted end tokens of every range, replicas[i] owns the range (tokens[i-1], tokens[i]]
+	tokens   []token
+	replicas [][]*node
+}
+
+type ringRange struct {
+	end      token
+	replicas []*node
+}
+
+type ringRanges []ringRange
+
+func (r ringRanges) Len() int           { return len(r) }
+func (r ringRanges) Less(i, j int) bool { return r[i].end.less(r[j].end) }
+func (r ringRanges) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+// newTokenRing builds a ring from the output of DescribeRing. hosts maps the endpoint
+// addresses reported by the server to pool nodes, endpoints without a pool node are ignored.
+func newTokenRing(p partitioner, trs []*cassandra.TokenRange, hosts map[string]*node) (*tokenRing, error) {
+	if len(trs) == 0 {
+		return nil, errors.New("Empty token ring")
+	}
+	ranges := make(ringRanges, 0, len(trs))
+	for _, tr := range trs {
+		end, err := p.parse(tr.EndToken)
+		if err != nil {
+			return nil, err
+		}
+		var replicas []*node
+		for _, e := range rangeEndpoints(tr) {
+			if n, ok := hosts[e]; ok {
+				replicas = append(replicas, n)
+			}
+		}
+		ranges = append(ranges, ringRange{end: end, replicas: replicas})
+	}
+	sort.Sort(ranges)
+
+	r := &tokenRing{
+		partitioner: p,
+		tokens:      make([]token, len(ranges)),
+		replicas:    make([][]*node, len(ranges)),
+	}
+	for i, rr := range ranges {
+		r.tokens[i] = rr.end
+		r.replicas[i] = rr.replicas
+	}
+	return r, nil
+}
+
+// rangeEndpoints returns the addresses clients should use to reach the replicas of a range
+func rangeEndpoints(tr *cassandra.TokenRange) []string {
+	if len(tr.RpcEndpoints) == len(tr.Endpoints) {
+		for _, e := range tr.RpcEndpoints {
+			if e == "0.0.0.0" || e == "::" {
+				return tr.Endpoints
+			}
+		}
+		return tr.RpcEndpoints
+	}
+	return tr.Endpoints
+}
+
+// replicasFor returns the pool nodes that own the given row key
+func (r *tokenRing) replicasFor(key []byte) []*node {
+	t := r.partitioner.hash(key)
+	i := sort.Search(len(r.tokens), func(i int) bool {
+		return !r.tokens[i].less(t)
+	})
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.replicas[i]
+}
+
+// hostIndex maps every address a pool node resolves to to that node
+func hostIndex(nodes []*node) map[string]*node {
+	hosts := make(map[string]*node)
+	for _, n := range nodes {
+		host, _, err := net.SplitHostPort(n.node)
+		if err != nil {
+			host = n.node
+		}
+		hosts[host] = n
+		addrs, err := lookupHost(host)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			hosts[addr] = n
+		}
+	}
+	return hosts
+}
+
+// runParallel calls f concurrently for every group of keys and returns the first error found
+func runParallel(groups [][][]byte, f func(keys [][]byte) error) error {
+	for _, err := range runGroups(groups, f) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGroups calls f concurrently for every group of keys and returns the error of each group
+func runGroups(groups [][][]byte, f func(keys [][]byte) error) []error {
+	if len(groups) == 1 {
+		return []error{f(groups[0])}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(groups))
+	for i, g := range groups {
+		wg.Add(1)
+		go func(i int, keys [][]byte) {
+			defer wg.Done()
+			errs[i] = f(keys)
+		}(i, g)
+	}
+	wg.Wait()
+	return errs
+}
+
+// murmur3H1 returns the first half of the x64 128 bit variant of MurmurHash3, including
+// the sign extension of the tail bytes done by the server implementation
+func murmur3H1(data []byte) int64 {
+	const (
+		c1 = -8663945395140668459 // 0x87c37b91114253d5
+		c2 = 5545529020109919103  // 0x4cf5ad432745937f
+	)
+	var h1, h2, k1, k2 int64
+
+	nblocks := len(data) / 16
+	for i := 0; i < nblocks; i++ {
+		k1 = int64(binary.LittleEndian.Uint64(data[i*16:]))
+		k2 = int64(binary.LittleEndian.Uint64(data[i*16+8:]))
+
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+		h1 = rotl64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		h2 = rotl64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	tail := data[nblocks*16:]
+	k1, k2 = 0, 0
+	switch len(tail) {
+	case 15:
+		k2 ^= int64(int8(tail[14])) << 48
+		fallthrough
+	case 14:
+		k2 ^= int64(int8(tail[13])) << 40
+		fallthrough
+	case 13:
+		k2 ^= int64(int8(tail[12])) << 32
+		fallthrough
+	case 12:
+		k2 ^= int64(int8(tail[11])) << 24
+		fallthrough
+	case 11:
+		k2 ^= int64(int8(tail[10])) << 16
+		fallthrough
+	case 10:
+		k2 ^= int64(int8(tail[9])) << 8
+		fallthrough
+	case 9:
+		k2 ^= int64(int8(tail[8]))
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		fallthrough
+	case 8:
+		k1 ^= int64(int8(tail[7])) << 56
+		fallthrough
+	case 7:
+		k1 ^= int64(int8(tail[6])) << 48
+		fallthrough
+	case 6:
+		k1 ^= int64(int8(tail[5])) << 40
+		fallthrough
+	case 5:
+		k1 ^= int64(int8(tail[4])) << 32
+		fallthrough
+	case 4:
+		k1 ^= int64(int8(tail[3])) << 24
+		fallthrough
+	case 3:
+		k1 ^= int64(int8(tail[2])) << 16
+		fallthrough
+	case 2:
+		k1 ^= int64(int8(tail[1])) << 8
+		fallthrough
+	case 1:
+		k1 ^= int64(int8(tail[0]))
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= int64(len(data))
+	h2 ^= int64(len(data))
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	h1 += h2
+	return h1
+}
+
+func rotl64(x int64, r uint) int64 {
+	return int64(uint64(x)<<r | uint64(x)>>(64-r))
+}
+
+func fmix64(k int64) int64 {
+	k ^= int64(uint64(k) >> 33)
+	k *= -49064778989728563 // 0xff51afd7ed558ccd
+	k ^= int64(uint64(k) >> 33)
+	k *= -4265267296055464877 // 0xc4ceb9fe1a85ec53
+	k ^= int64(uint64(k) >> 33)
+	return k
+}
+
+// replicasOf returns the pool nodes that own the given token, which is the end token of a range
+// of the ring or of a part of it, or nil if the token is not valid for the partitioner
+func (r *tokenRing) replicasOf(endToken string) []*node {
+	t, err := r.partitioner.parse(endToken)
+	if err != nil {
+		return nil
+	}
+	i := sort.Search(len(r.tokens), func(i int) bool {
+		return !r.tokens[i].less(t)
+	})
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.replicas[i]
+}
@@ -0,0 +1,96 @@
+package gossie
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+func TestMurmur3H1(t *testing.T) {
+	vectors := []struct {
+		data string
+		h1   uint64
+	}{
+		{"", 0x0000000000000000},
+		{"hello", 0xcbd8a7b341bd9b02},
+		{"hello, world", 0x342fac623a5ebc8e},
+		{"19 Jan 2038 at 3:14:07 AM", 0xb89e5988b737affc},
+		{"The quick brown fox jumps over the lazy dog.", 0xcd99481f9ee902c9},
+	}
+	for _, v := range vectors {
+		assert.Equal(t, int64(v.h1), murmur3H1([]byte(v.data)), v.data)
+	}
+}
+
+func TestNewPartitioner(t *testing.T) {
+	assert.Equal(t, murmur3Partitioner{}, newPartitioner("org.apache.cassandra.dht.Murmur3Partitioner"))
+	assert.Equal(t, randomPartitioner{}, newPartitioner("org.apache.cassandra.dht.RandomPartitioner"))
+	assert.Equal(t, byteOrderedPartitioner{}, newPartitioner("org.apache.cassandra.dht.ByteOrderedPartitioner"))
+	assert.Nil(t, newPartitioner("org.apache.cassandra.dht.OrderPreservingPartitioner"))
+}
+
+func TestRandomPartitionerHash(t *testing.T) {
+	// md5("") = d41d8cd98f00b204e9800998ecf8427e, a negative number as a signed integer
+	expected, _ := new(big.Int).SetString("58332598431525814501020785164969033090", 10)
+	assert.Equal(t, 0, randomPartitioner{}.hash([]byte{}).(randomToken).Cmp(expected))
+}
+
+func TestTokenRing(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9160"}
+	n2 := &node{node: "10.0.0.2:9160"}
+	n3 := &node{node: "10.0.0.3:9160"}
+	hosts := hostIndex([]*node{n1, n2, n3})
+
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "10", EndToken: "20", Endpoints: []string{"10.0.0.2", "10.0.0.3"}},
+		&cassandra.TokenRange{StartToken: "20", EndToken: "30", Endpoints: []string{"10.0.0.3", "10.0.0.1"}},
+		&cassandra.TokenRange{StartToken: "30", EndToken: "10", Endpoints: []string{"10.0.0.1", "10.0.0.2"},
+			RpcEndpoints: []string{"0.0.0.0", "0.0.0.0"}},
+	}
+	ring, err := newTokenRing(byteOrderedPartitioner{}, ranges, hosts)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []*node{n1, n2}, ring.replicasFor([]byte{0x05}))
+	assert.Equal(t, []*node{n1, n2}, ring.replicasFor([]byte{0x10}))
+	assert.Equal(t, []*node{n2, n3}, ring.replicasFor([]byte{0x11}))
+	assert.Equal(t, []*node{n2, n3}, ring.replicasFor([]byte{0x20}))
+	assert.Equal(t, []*node{n3, n1}, ring.replicasFor([]byte{0x25}))
+	assert.Equal(t, []*node{n1, n2}, ring.replicasFor([]byte{0x40}))
+	assert.Equal(t, []*node{n3, n1}, ring.replicasOf("30"))
+	assert.Equal(t, []*node{n3, n1}, ring.replicasOf("25"))
+	assert.Equal(t, []*node{n1, n2}, ring.replicasOf("40"))
+	assert.Nil(t, ring.replicasOf("invalid"))
+
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2, n3}, ring: ring}, options: DefaultPoolOptions}
+	groups := cp.splitByReplica([][]byte{{0x05}, {0x11}, {0x40}, {0x25}})
+	assert.Equal(t, [][][]byte{{{0x05}, {0x40}}, {{0x11}}, {{0x25}}}, groups)
+
+	// the keys go to their first replica in the local datacenter
+	cp.cluster.datacenters = map[*node]string{n1: "dc2", n2: "dc1", n3: "dc1"}
+	cp.options.LocalDatacenter = "dc1"
+	groups = cp.splitByReplica([][]byte{{0x05}, {0x11}, {0x40}, {0x25}})
+	assert.Equal(t, [][][]byte{{{0x05}, {0x11}, {0x40}}, {{0x25}}}, groups)
+	cp.cluster.datacenters, cp.options.LocalDatacenter = nil, ""
+
+	plan := cp.newQueryPlan([]byte{0x25}, int(nowfunc().Unix()))
+	assert.Equal(t, 3, len(plan.nodes))
+	assert.Contains(t, []*node{n3, n1}, plan.nodes[0])
+	assert.Contains(t, []*node{n3, n1}, plan.nodes[1])
+	assert.Equal(t, n2, plan.nodes[2])
+}
+
+func TestTokenRingUnknownEndpoints(t *testing.T) {
+	n1 := &node{node: "10.0.0.1:9160"}
+	ranges := []*cassandra.TokenRange{
+		&cassandra.TokenRange{StartToken: "0", EndToken: "-100", Endpoints: []string{"10.0.0.9"}},
+	}
+	ring, err := newTokenRing(murmur3Partitioner{}, ranges, hostIndex([]*node{n1}))
+	assert.NoError(t, err)
+	assert.Empty(t, ring.replicasFor([]byte("key")))
+
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1}, ring: ring}, options: DefaultPoolOptions}
+	plan := cp.newQueryPlan([]byte("key"), int(nowfunc().Unix()))
+	assert.Equal(t, []*node{n1}, plan.nodes)
+}
@@ -0,0 +1,165 @@
+package gossie
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	. "github.com/wadey/gossie/src/cassandra"
+)
+
+// RowScanner reads the rows of a token range in a background goroutine, see Reader.Scan
+type RowScanner interface {
+
+	// Next returns the next row of the scan. It returns nil and Done when there are no more rows,
+	// or the error that stopped the scan, like ctx.Err() when the scan context is done or Close was
+	// called.
+	Next() (*Row, error)
+
+	// Close stops the scan and waits until its goroutine returns, releasing its connection. It
+	// must be called when the scan is not read until Next returns an error.
+	Close()
+}
+
+type rowScanner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	rows   chan *Row
+	done   chan struct{} // closed when the scan goroutine returns
+	err    error         // error that stopped the scan, set before rows is closed
+	last   error         // returned by every call of Next once the rows were read
+}
+
+func (r *reader) SetScanBuffer(rows int) Reader {
+	r.scanBuffer = rows
+	return r
+}
+
+func (r *reader) Scan(ctx context.Context) RowScanner {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &rowScanner{
+		ctx:    ctx,
+		cancel: cancel,
+		rows:   make(chan *Row, r.scanBuffer),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		defer close(s.rows)
+		ctx, end := r.startSpan(ctx, "Reader.RangeScan", 0)
+		s.err = r.scan(ctx, r.tokenKeyRange(), nil, sendRows(ctx, s.rows))
+		end(s.err)
+	}()
+	return s
+}
+
+func (s *rowScanner) Next() (*Row, error) {
+	if s.last != nil {
+		return nil, s.last
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	row, ok := <-s.rows
+	if ok {
+		return row, nil
+	}
+	s.last = s.err
+	if s.last == nil {
+		s.last = Done
+	}
+	// the scan goroutine returned, so the scan context is not needed without calling Close
+	s.cancel()
+	return nil, s.last
+}
+
+func (s *rowScanner) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// tokenKeyRange returns the key range of the first page of a scan over the token range of the
+// reader, see SetTokenRange
+func (r *reader) tokenKeyRange() *KeyRange {
+	kr := NewKeyRange()
+	if len(r.startToken) != 0 {
+		kr.StartToken = &r.startToken
+	} else {
+		kr.StartToken = &DEF_START_TOKEN
+	}
+	if len(r.endToken) != 0 {
+		kr.EndToken = &r.endToken
+	} else {
+		kr.EndToken = &DEF_END_TOKEN
+	}
+	if len(r.expressions) != 0 {
+		kr.RowFilter = r.expressions
+	}
+	if r.tokenRangeCount > 0 {
+		kr.Count = int32(r.tokenRangeCount)
+	}
+	return kr
+}
+
+// sendRows returns a scan handler that sends the rows to out until ctx is done
+func sendRows(ctx context.Context, out chan<- *Row) func(*Row) error {
+	return func(row *Row) error {
+		select {
+		case out <- row:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// scan pages over the key range kr, starting after kr.StartKey if it is set, and calls handle
+// with every row until there are no more rows, returning the error that stopped it, if any
+func (r *reader) scan(ctx context.Context, kr *KeyRange, replicas []*node, handle func(*Row) error) error {
+	if r.columnParent.ColumnFamily == "" {
+		return errors.New("No column family specified")
+	}
+	sp := r.buildPredicate()
+	op := r.operation(nil)
+	op.replicas = replicas
+
+	for {
+		var ksv []*KeySlice
+		err := r.pool.runOperation(ctx, op, func(c *connection) error {
+			var err error
+			ksv, err = c.client.GetRangeSlices(&r.columnParent, sp, kr, c.consistency)
+			return err
+		})
+
+		if err != nil {
+			r.pool.options.Logger.Error("Error in GetRangeSlices", "error", err)
+			return err
+		}
+		r.pool.options.Logger.Debug("Key slice vector", "size", len(ksv))
+		if len(ksv) == 0 {
+			//phew. done
+			return nil
+		}
+		if kr.StartKey != nil && bytes.Equal(ksv[0].Key, kr.StartKey) {
+			//AP: I'm sending a diarrhea beam your way, dear designer of cassandra iteration
+			ksv = ksv[1:]
+		}
+		if len(ksv) == 0 {
+			//phew. done
+			return nil
+		}
+		kr.StartToken = nil
+		kr.StartKey = ksv[len(ksv)-1].Key //just in case it is mutable
+		r.pool.options.Logger.Debug("Next batch", "start", kr.StartKey)
+		for _, ks := range ksv {
+			r.pool.options.Logger.Debug("Raw row", "key", ks.Key, "columns", ks.Columns)
+			row := rowFromTListColumns(ks.Key, ks.Columns)
+			r.pool.options.Logger.Debug("Row", "row", row)
+			if row != nil {
+				if err := handle(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
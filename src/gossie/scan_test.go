@@ -0,0 +1,132 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	"github.com/wadey/gossie/src/gossie/mock_cassandra"
+)
+
+func TestScan(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	gomock.InOrder(
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(testKeySlices("a", "b"), nil),
+		cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(testKeySlices("b"), nil),
+	)
+	cp := testRangePool(cli)
+	cp.options.Logger = &testLogger{}
+
+	s := newReader(cp, CONSISTENCY_ONE).Cf("cf").SetScanBuffer(2).Scan(context.Background())
+	var keys []string
+	row, err := s.Next()
+	for ; err == nil; row, err = s.Next() {
+		keys = append(keys, string(row.Key))
+	}
+	assert.Equal(t, Done, err)
+	assert.Equal(t, []string{"a", "b"}, keys)
+
+	// the scan context is released without calling Close
+	assert.Equal(t, context.Canceled, s.(*rowScanner).ctx.Err())
+	_, err = s.Next()
+	assert.Equal(t, Done, err)
+}
+
+func TestRangeScanContextCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(testKeySlices("a", "b"), nil)
+	cp := testRangePool(cli)
+	cp.options.Logger = &testLogger{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	data, errc := newReader(cp, CONSISTENCY_ONE).Cf("cf").RangeScanContext(ctx)
+	row := <-data
+	assert.Equal(t, []byte("a"), row.Key)
+	cancel()
+	for range data {
+	}
+	assert.Equal(t, context.Canceled, <-errc)
+}
+
+func TestScanClose(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(testKeySlices("a", "b", "c"), nil)
+	cp := testRangePool(cli)
+	cp.options.Logger = &testLogger{}
+
+	s := newReader(cp, CONSISTENCY_ONE).Cf("cf").Scan(context.Background())
+	row, err := s.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), row.Key)
+
+	// the scan goroutine is blocked sending b, Close stops it and the connection is released
+	s.Close()
+	assert.Equal(t, 1, cp.cluster.nodes[0].available.Len())
+	_, err = s.Next()
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestScanError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	cli := mock_cassandra.NewMockCassandra(ctrl)
+	invalid := &InvalidRequestException{Why: "invalid"}
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(nil, invalid)
+	cp := testRangePool(cli)
+	cp.options.Logger = &testLogger{}
+
+	s := newReader(cp, CONSISTENCY_ONE).Cf("cf").Scan(context.Background())
+	defer s.Close()
+	_, err := s.Next()
+	assert.True(t, errors.Is(err, invalid))
+
+	// nobody reads the error channel of RangeScan, its goroutine still returns
+	cli.EXPECT().GetRangeSlices(gomock.Any(), gomock.Any(), gomock.Any(), ConsistencyLevel_ONE).Return(nil, invalid)
+	data, _ := newReader(cp, CONSISTENCY_ONE).Cf("cf").RangeScan()
+	for range data {
+	}
+}
+
+type sliceScanner struct {
+	rows   []*Row
+	err    error
+	closed bool
+}
+
+func (s *sliceScanner) Next() (*Row, error) {
+	if len(s.rows) == 0 {
+		return nil, s.err
+	}
+	row := s.rows[0]
+	s.rows = s.rows[1:]
+	return row, nil
+}
+
+func (s *sliceScanner) Close() {
+	s.closed = true
+}
+
+func TestStreamingResultScanner(t *testing.T) {
+	failed := &InvalidRequestException{Why: "invalid"}
+	s := &sliceScanner{rows: []*Row{&Row{Key: []byte("a")}}, err: failed}
+	r := &StreamingResult{Scanner: s}
+
+	key, err := r.Key()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), key)
+	r.row = nil
+	_, err = r.Key()
+	assert.Equal(t, failed, err)
+
+	r.Close()
+	assert.True(t, s.closed)
+}
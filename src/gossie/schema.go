@@ -22,6 +22,11 @@ type ColumnFamily struct {
 	DefaultValidator  TypeClass
 	KeyValidator      TypeClass
 	NamedColumns      map[string]TypeClass
+
+	// Super is true for super column families, where DefaultComparator sorts the super columns,
+	// SubComparator sorts their subcolumns and NamedColumns holds the subcolumns
+	Super         bool
+	SubComparator TypeClass
 }
 
 func newSchema(ksDef *cassandra.KsDef) *Schema {
@@ -30,12 +35,19 @@ func newSchema(ksDef *cassandra.KsDef) *Schema {
 
 	for _, cfDef := range cfDefs {
 
-		if cfDef.ColumnType != "Standard" {
+		cf := &ColumnFamily{}
+
+		switch cfDef.ColumnType {
+		case "Standard":
+		case "Super":
+			cf.Super = true
+			if cfDef.SubcomparatorType != nil {
+				cf.SubComparator = parseTypeClass(*cfDef.SubcomparatorType)
+			}
+		default:
 			continue
 		}
 
-		cf := &ColumnFamily{}
-
 		cf.DefaultComparator = parseTypeClass(cfDef.ComparatorType)
 		cf.DefaultValidator = parseTypeClass(*cfDef.DefaultValidationClass)
 		cf.KeyValidator = parseTypeClass(*cfDef.KeyValidationClass)
@@ -2,6 +2,9 @@ package gossie
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
 )
 
 func TestSchema(t *testing.T) {
@@ -17,8 +20,8 @@ func TestSchema(t *testing.T) {
 	schema := newSchema(ksDef)
 	defer c.close()
 
-	if len(schema.ColumnFamilies) != 8 {
-		t.Error("Test schema must have 8 CFs")
+	if len(schema.ColumnFamilies) != 9 {
+		t.Error("Test schema must have 9 CFs")
 	}
 
 	if schema.ColumnFamilies["AllTypes"] == nil {
@@ -150,4 +153,43 @@ func TestSchema(t *testing.T) {
 
 	}
 
+	if schema.ColumnFamilies["SuperTypes"] == nil {
+		t.Error("Test CF SuperTypes is nil")
+	} else {
+		cf := schema.ColumnFamilies["SuperTypes"]
+
+		if !cf.Super {
+			t.Error("Test CF SuperTypes is not a super CF")
+		}
+		if cf.DefaultComparator.Desc != AsciiType {
+			t.Error("Test CF SuperTypes DefaultComparator is not AsciiType")
+		}
+		if cf.SubComparator.Desc != LongType {
+			t.Error("Test CF SuperTypes SubComparator is not LongType")
+		}
+		if cf.DefaultValidator.Desc != UTF8Type {
+			t.Error("Test CF SuperTypes DefaultValidator is not UTF8Type")
+		}
+	}
+
+}
+
+func TestNewSchemaSuper(t *testing.T) {
+	validator := "org.apache.cassandra.db.marshal.BytesType"
+	subcomparator := "org.apache.cassandra.db.marshal.LongType"
+	schema := newSchema(&cassandra.KsDef{CfDefs: []*cassandra.CfDef{
+		&cassandra.CfDef{Name: "Standard", ColumnType: "Standard", ComparatorType: "org.apache.cassandra.db.marshal.AsciiType",
+			DefaultValidationClass: &validator, KeyValidationClass: &validator},
+		&cassandra.CfDef{Name: "Super", ColumnType: "Super", ComparatorType: "org.apache.cassandra.db.marshal.AsciiType",
+			SubcomparatorType: &subcomparator, DefaultValidationClass: &validator, KeyValidationClass: &validator},
+		&cassandra.CfDef{Name: "Unknown", ColumnType: "Unknown", ComparatorType: "org.apache.cassandra.db.marshal.AsciiType",
+			DefaultValidationClass: &validator, KeyValidationClass: &validator},
+	}})
+
+	assert.Equal(t, 2, len(schema.ColumnFamilies))
+	assert.False(t, schema.ColumnFamilies["Standard"].Super)
+	cf := schema.ColumnFamilies["Super"]
+	assert.True(t, cf.Super)
+	assert.Equal(t, TypeDesc(AsciiType), cf.DefaultComparator.Desc)
+	assert.Equal(t, TypeDesc(LongType), cf.SubComparator.Desc)
 }
@@ -0,0 +1,151 @@
+package gossie
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	latencySamples    = 1000 // latencies kept to compute SpeculativeQuantile
+	latencyMinSamples = 100  // latencies needed before SpeculativeQuantile is used
+	latencyRecompute  = 100  // the quantile is computed again after this many new latencies
+)
+
+// speculation coordinates the attempts of a speculative transaction so only the first one to
+// succeed stores its results, and shares the retry budget between them
+type speculation struct {
+	committed bool
+	m         sync.Mutex
+	attempts  int32
+}
+
+// attempt reserves one of the retries shared by all the attempts of the transaction and reports
+// if there was one left. It always succeeds for transactions that are not speculative.
+func (s *speculation) attempt(retries int) bool {
+	if s == nil {
+		return true
+	}
+	return int(atomic.AddInt32(&s.attempts, 1)) <= retries
+}
+
+// exhausted reports if the attempts of the transaction used all the retries
+func (s *speculation) exhausted(retries int) bool {
+	return s != nil && int(atomic.LoadInt32(&s.attempts)) >= retries
+}
+
+// commit calls store unless another attempt of the same speculative transaction already did.
+// Transactions that can run speculatively must store their results through it.
+func (c *connection) commit(store func()) {
+	if c.spec == nil {
+		store()
+		return
+	}
+	c.spec.m.Lock()
+	defer c.spec.m.Unlock()
+	if !c.spec.committed {
+		c.spec.committed = true
+		store()
+	}
+}
+
+// speculativeDelay returns how long to wait for the first attempt of a speculative
+// transaction before starting the second one, or 0 if speculative transactions are disabled
+func (cp *connectionPool) speculativeDelay() time.Duration {
+	if cp.options.SpeculativeQuantile > 0 {
+		if d, ok := cp.cluster.latencies.quantile(cp.options.SpeculativeQuantile); ok {
+			return d
+		}
+	}
+	return cp.options.SpeculativeDelay
+}
+
+// runSpeculative runs the transaction and, if it did not finish after delay, runs it again with
+// the next node of the plan. Both share the retries of op. The result of the first one to
+// succeed is returned and the other is cancelled, which discards its connection if it was still
+// waiting for the node. It waits for both to finish so no transaction is left running.
+func (cp *connectionPool) runSpeculative(ctx context.Context, op *operation, plan *queryPlan, t transaction, delay time.Duration) error {
+	sop := *op
+	sop.spec = &speculation{}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	run := func() {
+		errs <- cp.runAttempts(ctx, &sop, plan, t)
+	}
+	go run()
+	running := 1
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	for running > 0 {
+		select {
+		case <-timer.C:
+			cp.options.Logger.Debug("Sending speculative read", "delay", delay)
+			go run()
+			running++
+		case err = <-errs:
+			running--
+			if err == nil {
+				cancel()
+				for ; running > 0; running-- {
+					<-errs
+				}
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// latencies keeps the most recent latencies of a kind of transaction
+type latencies struct {
+	samples []time.Duration
+	next    int
+	added   int // since the quantile was computed
+	p       float64
+	cached  time.Duration
+	m       sync.Mutex
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.m.Lock()
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % latencySamples
+	}
+	l.added++
+	l.m.Unlock()
+}
+
+// quantile returns the p quantile of the latencies, and false if there are not enough of them
+func (l *latencies) quantile(p float64) (time.Duration, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if len(l.samples) < latencyMinSamples {
+		return 0, false
+	}
+	if l.added >= latencyRecompute || l.p != p {
+		sorted := make([]time.Duration, len(l.samples))
+		copy(sorted, l.samples)
+		sort.Sort(durations(sorted))
+		i := int(p * float64(len(sorted)))
+		if i >= len(sorted) {
+			i = len(sorted) - 1
+		}
+		l.cached, l.p, l.added = sorted[i], p, 0
+	}
+	return l.cached, true
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
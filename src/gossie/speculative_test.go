@@ -0,0 +1,139 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+type testSocket struct {
+	interrupted chan struct{}
+	once        sync.Once
+}
+
+func (s *testSocket) SetTimeout(timeout time.Duration) error { return nil }
+func (s *testSocket) Interrupt() error {
+	s.once.Do(func() { close(s.interrupted) })
+	return nil
+}
+
+func newTestConnection(n *node) *connection {
+	return &connection{
+		node:      n,
+		transport: thrift.NewTFramedTransport(thrift.NewTMemoryBuffer()),
+		socket:    &testSocket{interrupted: make(chan struct{})},
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	l := &latencies{}
+	_, ok := l.quantile(0.5)
+	assert.False(t, ok)
+	for i := 1; i <= latencySamples+500; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	d, ok := l.quantile(0.5)
+	assert.True(t, ok)
+	assert.Equal(t, 1001*time.Millisecond, d)
+	d, _ = l.quantile(1)
+	assert.Equal(t, 1500*time.Millisecond, d)
+}
+
+func TestSpeculativeDelay(t *testing.T) {
+	cp := &connectionPool{cluster: &cluster{}, options: DefaultPoolOptions}
+	assert.Equal(t, time.Duration(0), cp.speculativeDelay())
+	cp.options.SpeculativeDelay = time.Second
+	cp.options.SpeculativeQuantile = 0.9
+	assert.Equal(t, time.Second, cp.speculativeDelay())
+	for i := 0; i < latencyMinSamples; i++ {
+		cp.cluster.latencies.add(time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond, cp.speculativeDelay())
+}
+
+func TestRunSpeculative(t *testing.T) {
+	n1 := newNode("10.0.0.1:9160", 0)
+	n2 := newNode("10.0.0.2:9160", 0)
+	n1.available.Push(newTestConnection(n1))
+	n2.available.Push(newTestConnection(n2))
+	options := DefaultPoolOptions
+	options.SpeculativeDelay = time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+
+	var attempts int32
+	var slow *node
+	var result string
+	err := cp.runOperation(context.Background(), &operation{idempotent: true, speculative: true}, func(c *connection) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			slow = c.node
+			<-c.socket.(*testSocket).interrupted
+			c.commit(func() { result = "slow" })
+			return errors.New("interrupted")
+		}
+		c.commit(func() { result = "fast" })
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "fast", result)
+	assert.Equal(t, int32(2), attempts)
+
+	// the interrupted connection is closed, the other one is back in the pool
+	for _, n := range []*node{n1, n2} {
+		if n == slow {
+			assert.Equal(t, 0, n.available.Len())
+		} else {
+			assert.Equal(t, 1, n.available.Len())
+		}
+	}
+	_, ok := cp.cluster.latencies.quantile(0.5)
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(cp.cluster.latencies.samples))
+}
+
+func TestRunSpeculativeFailure(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	n.available.Push(newTestConnection(n))
+	options := DefaultPoolOptions
+	options.SpeculativeDelay = time.Hour
+	options.RetryPolicy = NewExponentialBackoffPolicy(time.Millisecond, time.Millisecond)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	invalid := errors.New("invalid")
+	attempts := 0
+	err := cp.runOperation(context.Background(), &operation{speculative: true}, func(c *connection) error {
+		attempts++
+		return invalid
+	})
+	assert.True(t, errors.Is(err, invalid))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRunSpeculativeRetries(t *testing.T) {
+	n1 := newNode("10.0.0.1:9160", 0)
+	n2 := newNode("10.0.0.2:9160", 0)
+	for i := 0; i < 2; i++ {
+		n1.available.Push(newTestConnection(n1))
+		n2.available.Push(newTestConnection(n2))
+	}
+	options := DefaultPoolOptions
+	options.SpeculativeDelay = time.Millisecond
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n1, n2}}, options: options}
+
+	var attempts int32
+	err := cp.runOperation(context.Background(), &operation{idempotent: true, speculative: true, retries: 2}, func(c *connection) error {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return cassandra.NewUnavailableException()
+	})
+	assert.Error(t, err)
+	// both attempts share the retries of the operation
+	assert.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+}
@@ -0,0 +1,107 @@
+package gossie
+
+import (
+	"context"
+	"time"
+
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+// OperationInfo describes an operation traced by an OperationTracer. The operations started
+// by the Reader, Writer and Query methods have a Name like "Reader.Get" or "Writer.Run", and
+// every attempt the pool makes to run them on a node is traced too, as a child span with the
+// same Name, ColumnFamily and Keys and the Attempt and Node set.
+type OperationInfo struct {
+	Name         string                     // method that started the operation, empty for pool internal transactions
+	ColumnFamily string                     // empty if not known or if the operation uses more than one
+	Keys         int                        // number of row keys, 0 for range operations
+	Consistency  cassandra.ConsistencyLevel // 0 if not known
+	Attempt      int                        // number of the attempt, starting at 1, or 0 for the operation itself
+	Node         string                     // "host:port" address of the node running the attempt
+	Parent       OperationSpan              // span of the enclosing operation, nil if none
+}
+
+// OperationTracer receives the start of every operation and attempt, see
+// PoolOptions.OperationTracer. Its methods are called concurrently and must not block. Panics
+// in them are logged and stop the tracing of the operation, but do not make it fail.
+type OperationTracer interface {
+	// Start is called when an operation or attempt starts and returns the span that will
+	// receive its end
+	Start(op OperationInfo) OperationSpan
+}
+
+// OperationSpan is an operation or attempt traced by an OperationTracer
+type OperationSpan interface {
+	// End is called once when the operation or attempt finishes after running for duration,
+	// with the error it returned
+	End(err error, duration time.Duration)
+}
+
+type spanKey struct{}
+
+// activeSpan is the span stored in the context of the operation it traces
+type activeSpan struct {
+	span OperationSpan
+	info OperationInfo
+}
+
+// startSpan starts tracing an operation, returning the context to run it with and the function
+// to call with its result
+func (cp *connectionPool) startSpan(ctx context.Context, info OperationInfo) (context.Context, func(error)) {
+	if cp.options.OperationTracer == nil {
+		return ctx, func(error) {}
+	}
+	if parent, ok := ctx.Value(spanKey{}).(*activeSpan); ok {
+		info.Parent = parent.span
+	}
+	span := cp.startTraced(info)
+	if span == nil {
+		return ctx, func(error) {}
+	}
+	start := time.Now()
+	ctx = context.WithValue(ctx, spanKey{}, &activeSpan{span: span, info: info})
+	return ctx, func(err error) {
+		cp.endTraced(span, err, time.Since(start))
+	}
+}
+
+// startAttempt starts tracing an attempt of the operation running with ctx on the given node
+func (cp *connectionPool) startAttempt(ctx context.Context, attempt int, node string, consistency cassandra.ConsistencyLevel) func(error) {
+	if cp.options.OperationTracer == nil {
+		return func(error) {}
+	}
+	var info OperationInfo
+	if parent, ok := ctx.Value(spanKey{}).(*activeSpan); ok {
+		info = parent.info
+		info.Parent = parent.span
+	}
+	info.Attempt, info.Node, info.Consistency = attempt, node, consistency
+	span := cp.startTraced(info)
+	if span == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		cp.endTraced(span, err, time.Since(start))
+	}
+}
+
+// startTraced calls the OperationTracer, returning nil if it panicked
+func (cp *connectionPool) startTraced(info OperationInfo) (span OperationSpan) {
+	defer func() {
+		if r := recover(); r != nil {
+			cp.options.Logger.Error("OperationTracer panicked", "operation", info.Name, "panic", r)
+			span = nil
+		}
+	}()
+	return cp.options.OperationTracer.Start(info)
+}
+
+func (cp *connectionPool) endTraced(span OperationSpan, err error, duration time.Duration) {
+	defer func() {
+		if r := recover(); r != nil {
+			cp.options.Logger.Error("OperationSpan panicked", "panic", r)
+		}
+	}()
+	span.End(err, duration)
+}
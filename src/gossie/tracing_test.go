@@ -0,0 +1,89 @@
+package gossie
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wadey/gossie/src/cassandra"
+)
+
+type testSpan struct {
+	info  OperationInfo
+	err   error
+	ended bool
+}
+
+func (s *testSpan) End(err error, duration time.Duration) {
+	s.err, s.ended = err, true
+}
+
+type testTracer struct {
+	spans []*testSpan
+	m     sync.Mutex
+}
+
+func (t *testTracer) Start(op OperationInfo) OperationSpan {
+	t.m.Lock()
+	defer t.m.Unlock()
+	s := &testSpan{info: op}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+func TestOperationTracer(t *testing.T) {
+	n := newNode(localEndpoint, 0)
+	n.available.Push(newTestConnection(n))
+	tracer := &testTracer{}
+	options := DefaultPoolOptions
+	options.OperationTracer = tracer
+	options.RetryPolicy = NewExponentialBackoffPolicy(time.Millisecond, time.Millisecond)
+	cp := &connectionPool{cluster: &cluster{nodes: []*node{n}}, options: options}
+
+	ctx, end := cp.startSpan(context.Background(), OperationInfo{
+		Name:         "Reader.Get",
+		ColumnFamily: "Timeline",
+		Keys:         1,
+		Consistency:  cassandra.ConsistencyLevel_QUORUM,
+	})
+	attempts := 0
+	unavailable := cassandra.NewUnavailableException()
+	err := cp.runOperation(ctx, &operation{retries: 2, idempotent: true}, func(c *connection) error {
+		attempts++
+		if attempts == 1 {
+			return unavailable
+		}
+		return nil
+	})
+	end(err)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, len(tracer.spans))
+	op := tracer.spans[0]
+	assert.Equal(t, "Reader.Get", op.info.Name)
+	assert.Equal(t, 0, op.info.Attempt)
+	assert.Nil(t, op.info.Parent)
+	assert.True(t, op.ended)
+	for i, s := range tracer.spans[1:] {
+		assert.Equal(t, "Reader.Get", s.info.Name)
+		assert.Equal(t, "Timeline", s.info.ColumnFamily)
+		assert.Equal(t, 1, s.info.Keys)
+		assert.Equal(t, i+1, s.info.Attempt)
+		assert.Equal(t, localEndpoint, s.info.Node)
+		assert.Equal(t, op, s.info.Parent)
+		assert.True(t, s.ended)
+	}
+	assert.Equal(t, unavailable, tracer.spans[1].err)
+	assert.NoError(t, tracer.spans[2].err)
+}
+
+func TestOperationTracerDisabled(t *testing.T) {
+	cp := &connectionPool{cluster: &cluster{}, options: DefaultPoolOptions}
+	ctx := context.Background()
+	sctx, end := cp.startSpan(ctx, OperationInfo{Name: "Reader.Get"})
+	assert.Equal(t, ctx, sctx)
+	end(errors.New("failed"))
+}
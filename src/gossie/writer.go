@@ -1,6 +1,8 @@
 package gossie
 
 import (
+	"context"
+
 	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/wadey/gossie/src/cassandra"
 )
@@ -14,16 +16,21 @@ type Writer interface {
 	// pool options value.
 	ConsistencyLevel(cassandra.ConsistencyLevel) Writer
 
-	// Insert adds a new row insertion to the mutation
+	// Trace makes Cassandra trace the requests sent to run this mutation, storing the session of
+	// the last one in session. Use ConnectionPool.QueryTrace to read the trace.
+	Trace(session *UUID) Writer
+
+	// Insert adds a new row insertion to the mutation, the SuperColumns of the row insert their
+	// subcolumns in a super column family
 	Insert(cf string, row *Row) Writer
 
 	// InsertTtl adds a new row insertion to the mutation, overriding the
 	// columns Ttl with the passed value
 	InsertTtl(cf string, row *Row, ttl int) Writer
 
-	// DeltaCounters add a new delta operation over counters. When using this
-	// function the number of retries for the connection is temporally set to
-	// 1.
+	// DeltaCounters add a new delta operation over counters, including the subcolumns of the
+	// SuperColumns of the row. When using this function the number of retries for the
+	// connection is temporally set to 1.
 	DeltaCounters(cf string, row *Row) Writer
 
 	// Delete deletes a single row specified by key
@@ -32,8 +39,23 @@ type Writer interface {
 	// DeleteColumns deletes the passed columns from the row specified by key.
 	DeleteColumns(cf string, key []byte, columns [][]byte) Writer
 
-	// Run this mutation
+	// DeleteSuperColumn deletes a super column, with all its subcolumns, from the row specified
+	// by key
+	DeleteSuperColumn(cf string, key []byte, superColumn []byte) Writer
+
+	// DeleteSubColumns deletes the passed subcolumns of a super column from the row specified by
+	// key
+	DeleteSubColumns(cf string, key []byte, superColumn []byte, columns [][]byte) Writer
+
+	// Run this mutation. The rows are sent in one batch per replica, in parallel, when the token
+	// ring is known. The batches are not atomic as a whole: when some of them fail the others
+	// are still applied, and a *PartialWriteError lists the keys of the rows that may not have
+	// been written.
 	Run() error
+
+	// RunContext runs this mutation, giving up when ctx is done and returning ctx.Err(). Some
+	// of the mutations may have been applied when that happens.
+	RunContext(ctx context.Context) error
 }
 
 type writer struct {
@@ -41,6 +63,7 @@ type writer struct {
 	consistencyLevel cassandra.ConsistencyLevel
 	writers          map[string]map[string][]*cassandra.Mutation
 	usedCounters     bool
+	trace            *traceSession
 }
 
 func newWriter(cp connectionRunner, cl cassandra.ConsistencyLevel) *writer {
@@ -70,6 +93,11 @@ func (w *writer) ConsistencyLevel(l cassandra.ConsistencyLevel) Writer {
 	return w
 }
 
+func (w *writer) Trace(session *UUID) Writer {
+	w.trace = newTraceSession(session)
+	return w
+}
+
 func (w *writer) Insert(cf string, row *Row) Writer {
 	return w.InsertTtl(cf, row, -1)
 }
@@ -78,38 +106,73 @@ func (w *writer) InsertTtl(cf string, row *Row, ttl int) Writer {
 	t := now()
 	for _, col := range row.Columns {
 		tm := w.addWriter(cf, row.Key)
-		c := cassandra.NewColumn()
-		c.Name = col.Name
-		c.Value = col.Value
-		if ttl > 0 {
-			c.Ttl = thrift.Int32Ptr(int32(ttl))
-		}
-		if col.Timestamp != nil {
-			c.Timestamp = col.Timestamp
-		} else {
-			c.Timestamp = &t
+		cs := cassandra.NewColumnOrSuperColumn()
+		cs.Column = newColumn(col, ttl, t)
+		tm.ColumnOrSupercolumn = cs
+	}
+	for _, sc := range row.SuperColumns {
+		tm := w.addWriter(cf, row.Key)
+		s := cassandra.NewSuperColumn()
+		s.Name = sc.Name
+		s.Columns = make([]*cassandra.Column, 0, len(sc.Columns))
+		for _, col := range sc.Columns {
+			s.Columns = append(s.Columns, newColumn(col, ttl, t))
 		}
 		cs := cassandra.NewColumnOrSuperColumn()
-		cs.Column = c
+		cs.SuperColumn = s
 		tm.ColumnOrSupercolumn = cs
 	}
 	return w
 }
 
+// newColumn returns the column to insert, with the given ttl if it is greater than 0 and
+// timestamp t if it has none
+func newColumn(col *cassandra.Column, ttl int, t int64) *cassandra.Column {
+	c := cassandra.NewColumn()
+	c.Name = col.Name
+	c.Value = col.Value
+	if ttl > 0 {
+		c.Ttl = thrift.Int32Ptr(int32(ttl))
+	}
+	if col.Timestamp != nil {
+		c.Timestamp = col.Timestamp
+	} else {
+		c.Timestamp = &t
+	}
+	return c
+}
+
 func (w *writer) DeltaCounters(cf string, row *Row) Writer {
 	for _, col := range row.Columns {
 		tm := w.addWriter(cf, row.Key)
-		c := cassandra.NewCounterColumn()
-		c.Name = col.Name
-		Unmarshal(col.Value, LongType, &c.Value)
 		cs := cassandra.NewColumnOrSuperColumn()
-		cs.CounterColumn = c
+		cs.CounterColumn = newCounterColumn(col)
+		tm.ColumnOrSupercolumn = cs
+	}
+	for _, sc := range row.SuperColumns {
+		tm := w.addWriter(cf, row.Key)
+		s := cassandra.NewCounterSuperColumn()
+		s.Name = sc.Name
+		s.Columns = make([]*cassandra.CounterColumn, 0, len(sc.Columns))
+		for _, col := range sc.Columns {
+			s.Columns = append(s.Columns, newCounterColumn(col))
+		}
+		cs := cassandra.NewColumnOrSuperColumn()
+		cs.CounterSuperColumn = s
 		tm.ColumnOrSupercolumn = cs
 	}
 	w.usedCounters = true
 	return w
 }
 
+// newCounterColumn returns the counter delta of a column with a LongType value
+func newCounterColumn(col *cassandra.Column) *cassandra.CounterColumn {
+	c := cassandra.NewCounterColumn()
+	c.Name = col.Name
+	Unmarshal(col.Value, LongType, &c.Value)
+	return c
+}
+
 func (w *writer) Delete(cf string, key []byte) Writer {
 	tm := w.addWriter(cf, key)
 	d := cassandra.NewDeletion()
@@ -129,6 +192,27 @@ func (w *writer) DeleteColumns(cf string, key []byte, columns [][]byte) Writer {
 	return w
 }
 
+func (w *writer) DeleteSuperColumn(cf string, key []byte, superColumn []byte) Writer {
+	tm := w.addWriter(cf, key)
+	d := cassandra.NewDeletion()
+	d.Timestamp = thrift.Int64Ptr(now())
+	d.SuperColumn = superColumn
+	tm.Deletion = d
+	return w
+}
+
+func (w *writer) DeleteSubColumns(cf string, key []byte, superColumn []byte, columns [][]byte) Writer {
+	tm := w.addWriter(cf, key)
+	d := cassandra.NewDeletion()
+	d.Timestamp = thrift.Int64Ptr(now())
+	d.SuperColumn = superColumn
+	sp := cassandra.NewSlicePredicate()
+	sp.ColumnNames = columns
+	d.Predicate = sp
+	tm.Deletion = d
+	return w
+}
+
 /* InvalidRequestException({TStruct:InvalidRequestException Why:Deletion does not yet support SliceRange predicates.})
 func (w *writer) DeleteSlice(cf string, key []byte, slice *Slice) Writer {
     tm := w.addWriter(cf, key)
@@ -143,11 +227,86 @@ func (w *writer) DeleteSlice(cf string, key []byte, slice *Slice) Writer {
 */
 
 func (w *writer) Run() error {
-	toRun := func(c *connection) error {
-		return c.client.BatchMutate(w.writers, cassandra.ConsistencyLevel(w.consistencyLevel))
-	}
+	return w.RunContext(context.Background())
+}
+
+func (w *writer) RunContext(ctx context.Context) (err error) {
+	ctx, end := w.startSpan(ctx)
+	defer func() { end(err) }()
+
+	// counter updates are not idempotent, applying them twice counts twice
+	op := operation{consistency: w.consistencyLevel, idempotent: !w.usedCounters, trace: w.trace}
 	if w.usedCounters {
-		return w.pool.runWithRetries(toRun, 1)
+		op.retries = 1
+	}
+	if len(w.writers) <= 1 {
+		for skey := range w.writers {
+			op.key = []byte(skey)
+		}
+		return w.runMutations(ctx, &op, w.writers)
+	}
+
+	// mutations are sent to their replicas in parallel, one BatchMutate per replica
+	keys := make([][]byte, 0, len(w.writers))
+	for skey := range w.writers {
+		keys = append(keys, []byte(skey))
+	}
+	groups := w.pool.splitByReplica(keys)
+	errs := runGroups(groups, func(keys [][]byte) error {
+		mutations := w.writers
+		if len(keys) != len(w.writers) {
+			mutations = make(map[string]map[string][]*cassandra.Mutation, len(keys))
+			for _, key := range keys {
+				mutations[string(key)] = w.writers[string(key)]
+			}
+		}
+		op := op
+		op.key = keys[0]
+		return w.runMutations(ctx, &op, mutations)
+	})
+	if len(groups) == 1 {
+		return errs[0]
+	}
+	var partial *PartialWriteError
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if partial == nil {
+			partial = &PartialWriteError{Err: err}
+		}
+		partial.Failed = append(partial.Failed, groups[i]...)
+	}
+	if partial != nil {
+		return partial
+	}
+	return nil
+}
+
+// startSpan starts tracing the mutation, the ColumnFamily is only set if all its mutations
+// are on the same one
+func (w *writer) startSpan(ctx context.Context) (context.Context, func(error)) {
+	info := OperationInfo{Name: "Writer.Run", Keys: len(w.writers), Consistency: w.consistencyLevel}
+	for _, cfs := range w.writers {
+		for cf := range cfs {
+			if info.ColumnFamily == "" {
+				info.ColumnFamily = cf
+			} else if info.ColumnFamily != cf {
+				info.ColumnFamily = ""
+				return w.pool.startSpan(ctx, info)
+			}
+		}
+	}
+	return w.pool.startSpan(ctx, info)
+}
+
+func (w *writer) runMutations(ctx context.Context, op *operation, mutations map[string]map[string][]*cassandra.Mutation) error {
+	for _, cfs := range mutations {
+		for _, ms := range cfs {
+			op.mutations += len(ms)
+		}
 	}
-	return w.pool.run(toRun)
+	return w.pool.runOperation(ctx, op, func(c *connection) error {
+		return c.client.BatchMutate(mutations, c.consistency)
+	})
 }
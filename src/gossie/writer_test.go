@@ -1,9 +1,12 @@
 package gossie
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"code.google.com/p/gomock/gomock"
+	"github.com/stretchr/testify/assert"
 	. "github.com/wadey/gossie/src/cassandra"
 	"github.com/wadey/gossie/src/gossie/mock_cassandra"
 )
@@ -20,6 +23,35 @@ func (s *stubTransactionRunner) runWithRetries(t transaction, retries int) error
 	t(s.conn)
 	return nil
 }
+func (s *stubTransactionRunner) runOperation(ctx context.Context, op *operation, t transaction) error {
+	s.conn.consistency = op.consistency
+	t(s.conn)
+	return nil
+}
+func (s *stubTransactionRunner) startSpan(ctx context.Context, info OperationInfo) (context.Context, func(error)) {
+	return ctx, func(error) {}
+}
+func (s *stubTransactionRunner) splitByReplica(keys [][]byte) [][][]byte {
+	return [][][]byte{keys}
+}
+
+// splitTransactionRunner sends every key to its own replica and fails the operations on the
+// failed keys
+type splitTransactionRunner struct {
+	stubTransactionRunner
+	failed map[string]error
+}
+
+func (s *splitTransactionRunner) runOperation(ctx context.Context, op *operation, t transaction) error {
+	return s.failed[string(op.key)]
+}
+func (s *splitTransactionRunner) splitByReplica(keys [][]byte) [][][]byte {
+	groups := make([][][]byte, len(keys))
+	for i, key := range keys {
+		groups[i] = [][]byte{key}
+	}
+	return groups
+}
 
 func NewBytes(in []byte) *[]byte { return &in }
 
@@ -49,3 +81,51 @@ func TestWriterInsert(t *testing.T) {
 		t.Error("Error", e)
 	}
 }
+
+func TestWriterPartialWrite(t *testing.T) {
+	unavailable := NewUnavailableException()
+	cp := &splitTransactionRunner{failed: map[string]error{"b": unavailable, "c": unavailable}}
+	w := newWriter(cp, CONSISTENCY_ONE)
+	for _, key := range []string{"a", "b", "c"} {
+		w.Insert("cf", &Row{Key: []byte(key), Columns: []*Column{&Column{Name: []byte("name"), Value: []byte("value")}}})
+	}
+	err := w.Run()
+	var partial *PartialWriteError
+	assert.True(t, errors.As(err, &partial))
+	assert.ElementsMatch(t, [][]byte{[]byte("b"), []byte("c")}, partial.Failed)
+	assert.True(t, errors.Is(err, unavailable))
+
+	cp.failed = nil
+	assert.NoError(t, w.Run())
+}
+
+func TestWriterSuperColumns(t *testing.T) {
+	ts := int64(1000)
+	w := newWriter(&stubTransactionRunner{}, CONSISTENCY_ONE)
+	w.InsertTtl("super", &Row{Key: []byte("key"), SuperColumns: []*SuperColumn{
+		&SuperColumn{Name: []byte("sc"), Columns: []*Column{&Column{Name: []byte("name"), Value: []byte("value"), Timestamp: &ts}}},
+	}}, 60)
+	w.DeltaCounters("counters", &Row{Key: []byte("key"), SuperColumns: []*SuperColumn{
+		&SuperColumn{Name: []byte("sc"), Columns: []*Column{&Column{Name: []byte("count"), Value: []byte{0, 0, 0, 0, 0, 0, 0, 2}}}},
+	}})
+	w.DeleteSuperColumn("super", []byte("key"), []byte("old"))
+	w.DeleteSubColumns("super", []byte("key"), []byte("sc"), [][]byte{[]byte("gone")})
+	assert.True(t, w.usedCounters)
+
+	super := w.writers["key"]["super"]
+	assert.Equal(t, 3, len(super))
+	ttl := int32(60)
+	assert.Equal(t, &SuperColumn{Name: []byte("sc"), Columns: []*Column{
+		&Column{Name: []byte("name"), Value: []byte("value"), Timestamp: &ts, Ttl: &ttl},
+	}}, super[0].ColumnOrSupercolumn.SuperColumn)
+	assert.Equal(t, []byte("old"), super[1].Deletion.SuperColumn)
+	assert.Nil(t, super[1].Deletion.Predicate)
+	assert.Equal(t, []byte("sc"), super[2].Deletion.SuperColumn)
+	assert.Equal(t, [][]byte{[]byte("gone")}, super[2].Deletion.Predicate.ColumnNames)
+
+	counters := w.writers["key"]["counters"]
+	assert.Equal(t, 1, len(counters))
+	assert.Equal(t, &CounterSuperColumn{Name: []byte("sc"), Columns: []*CounterColumn{
+		&CounterColumn{Name: []byte("count"), Value: 2},
+	}}, counters[0].ColumnOrSupercolumn.CounterSuperColumn)
+}
@@ -1,6 +1,8 @@
 package mockgossie
 
 import (
+	"context"
+
 	. "github.com/wadey/gossie/src/cassandra"
 	. "github.com/wadey/gossie/src/gossie"
 )
@@ -82,3 +84,10 @@ func (b *MockBatch) Run() error {
 	}
 	return b.writer.Run()
 }
+
+func (b *MockBatch) RunContext(ctx context.Context) error {
+	if b.mappingError != nil {
+		return b.mappingError
+	}
+	return b.writer.RunContext(ctx)
+}
@@ -13,6 +13,7 @@ package mockgossie
 
 import (
 	"bytes"
+	"context"
 	"sort"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -41,6 +42,86 @@ func (*MockConnectionPool) Close()           {}
 
 func (m *MockConnectionPool) WithTracer(Tracer) ConnectionPool { return m }
 
+func (m *MockConnectionPool) WithLimits(Limits) ConnectionPool { return m }
+
+func (*MockConnectionPool) Stats() PoolStats { return PoolStats{} }
+
+func (*MockConnectionPool) QueryTrace(UUID) (*TraceSession, error) { return nil, ErrTraceNotFound }
+
+func (*MockConnectionPool) AddNode(string)          {}
+func (*MockConnectionPool) RemoveNode(string) error { return nil }
+func (*MockConnectionPool) Nodes() []NodeInfo       { return nil }
+func (*MockConnectionPool) MarkDown(string) error   { return nil }
+func (*MockConnectionPool) MarkUp(string) error     { return nil }
+
+func (m *MockConnectionPool) ParallelScan(cf string, workers int, handler ScanHandler) ParallelScanner {
+	return &MockParallelScanner{reader: newReader(m), cf: cf, handler: handler}
+}
+
+// MockParallelScanner scans the rows of the column family as a single split
+type MockParallelScanner struct {
+	reader     *MockReader
+	cf         string
+	handler    ScanHandler
+	checkpoint ScanCheckpoint
+}
+
+var _ ParallelScanner = &MockParallelScanner{}
+
+func (s *MockParallelScanner) ConsistencyLevel(ConsistencyLevel) ParallelScanner { return s }
+func (s *MockParallelScanner) Where([]byte, Operator, []byte) ParallelScanner {
+	panic("not implemented")
+}
+func (s *MockParallelScanner) SplitSize(int) ParallelScanner { return s }
+func (s *MockParallelScanner) PageSize(int) ParallelScanner  { return s }
+func (s *MockParallelScanner) Retries(int) ParallelScanner   { return s }
+
+func (s *MockParallelScanner) Slice(slice *Slice) ParallelScanner {
+	s.reader.Slice(slice)
+	return s
+}
+
+func (s *MockParallelScanner) Checkpoint(checkpoint ScanCheckpoint) ParallelScanner {
+	s.checkpoint = checkpoint
+	return s
+}
+
+func (s *MockParallelScanner) Run(ctx context.Context) error {
+	split := TokenSplit{StartToken: DEF_START_TOKEN, EndToken: DEF_END_TOKEN}
+	if s.checkpoint != nil {
+		plan, err := s.checkpoint.Plan()
+		if err != nil {
+			return err
+		}
+		if len(plan) == 0 {
+			if err := s.checkpoint.SetPlan([]TokenSplit{split}); err != nil {
+				return err
+			}
+		}
+		if s.checkpoint.Completed(split) {
+			return nil
+		}
+	}
+	scanner := s.reader.Cf(s.cf).Scan(ctx)
+	defer scanner.Close()
+	for {
+		row, err := scanner.Next()
+		if err == Done {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handler(row); err != nil {
+			return err
+		}
+	}
+	if s.checkpoint != nil {
+		return s.checkpoint.Complete(split)
+	}
+	return nil
+}
+
 func (m *MockConnectionPool) Query(mapping Mapping) Query {
 	return &MockQuery{
 		pool:        m,
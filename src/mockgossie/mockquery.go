@@ -2,6 +2,7 @@ package mockgossie
 
 import (
 	"bytes"
+	"context"
 	enc "encoding/binary"
 	"reflect"
 
@@ -207,6 +208,24 @@ func (m *MockQuery) Get(key interface{}) (Result, error) {
 	return m.MultiGet([]interface{}{key})
 }
 
+func (m *MockQuery) GetContext(ctx context.Context, key interface{}) (Result, error) {
+	return m.MultiGetContext(ctx, []interface{}{key})
+}
+
+func (m *MockQuery) MultiGetContext(ctx context.Context, keys []interface{}) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.MultiGet(keys)
+}
+
+func (m *MockQuery) RangeGetContext(ctx context.Context, r *Range) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.RangeGet(r)
+}
+
 func (m *MockQuery) GetOne(key interface{}, destination interface{}) error {
 	res, err := m.Get(key)
 	if err != nil {
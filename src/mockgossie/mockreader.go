@@ -2,6 +2,8 @@ package mockgossie
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 
 	. "github.com/wadey/gossie/src/cassandra"
 	. "github.com/wadey/gossie/src/gossie"
@@ -13,6 +15,7 @@ type MockReader struct {
 	rowLimit    int
 	cf          string
 	slice       *Slice
+	superColumn []byte
 
 	startToken string
 	endToken   string
@@ -25,6 +28,8 @@ func (m *MockReader) Columns([][]byte) Reader                               { pa
 func (m *MockReader) Where(column []byte, op Operator, value []byte) Reader { panic("not implemented") }
 func (m *MockReader) IndexedGet(*IndexedRange) ([]*Row, error)              { panic("not implemented") }
 func (m *MockReader) SetTokenRangeCount(count int) Reader                   { return m }
+func (m *MockReader) SetScanBuffer(rows int) Reader                         { return m }
+func (m *MockReader) Trace(*UUID) Reader                                    { return m }
 func (m *MockReader) WideRowScan(key, startColumn []byte, batchSize int32, callback func(*Column) bool) error {
 	panic("not implemented")
 }
@@ -47,6 +52,11 @@ func (m *MockReader) Slice(s *Slice) Reader {
 	return m
 }
 
+func (m *MockReader) SuperColumn(name []byte) Reader {
+	m.superColumn = name
+	return m
+}
+
 func (m *MockReader) SetTokenRange(startToken, endToken string) Reader {
 	// For now, only allow a token range that is equivalent to the whole range
 	if startToken == "-1" && endToken == "170141183460469231731687303715884105728" {
@@ -128,8 +138,13 @@ func (m *MockReader) MultiGet(keys [][]byte) ([]*Row, error) {
 }
 
 func (m *MockReader) RangeScan() (<-chan *Row, <-chan error) {
+	return m.RangeScanContext(context.Background())
+}
+
+func (m *MockReader) RangeScanContext(ctx context.Context) (<-chan *Row, <-chan error) {
 	data := make(chan *Row)
-	errc := make(chan error)
+	// buffered so the goroutine does not block when nobody reads the error
+	errc := make(chan error, 1)
 
 	go func() {
 		defer close(data)
@@ -138,14 +153,108 @@ func (m *MockReader) RangeScan() (<-chan *Row, <-chan error) {
 		rows := m.pool.Rows(m.cf)
 		for _, row := range rows {
 			checkExpired(row)
-			data <- m.sliceRow(row)
+			select {
+			case data <- m.sliceRow(row):
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
 		}
 	}()
 
 	return data, errc
 }
 
+func (m *MockReader) Scan(ctx context.Context) RowScanner {
+	ctx, cancel := context.WithCancel(ctx)
+	data, _ := m.RangeScanContext(ctx)
+	return &mockRowScanner{ctx: ctx, cancel: cancel, data: data}
+}
+
+type mockRowScanner struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	data   <-chan *Row
+	done   bool
+}
+
+func (s *mockRowScanner) Next() (*Row, error) {
+	if s.done {
+		return nil, Done
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	row, ok := <-s.data
+	if !ok {
+		if err := s.ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.done = true
+		s.cancel()
+		return nil, Done
+	}
+	return row, nil
+}
+
+func (s *mockRowScanner) Close() {
+	s.cancel()
+	for range s.data {
+	}
+}
+
+func (m *MockReader) GetContext(ctx context.Context, key []byte) (*Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Get(key)
+}
+
+func (m *MockReader) MultiGetContext(ctx context.Context, keys [][]byte) ([]*Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.MultiGet(keys)
+}
+
+func (m *MockReader) CountContext(ctx context.Context, key []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return m.Count(key)
+}
+
+func (m *MockReader) MultiCountContext(ctx context.Context, keys [][]byte) ([]*RowColumnCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.MultiCount(keys)
+}
+
+func (m *MockReader) RangeGetContext(ctx context.Context, r *Range) ([]*Row, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.RangeGet(r)
+}
+
+// superRow returns a row holding the subcolumns of the super column set with SuperColumn as its
+// columns, or r if it is not set
+func (m *MockReader) superRow(r *Row) *Row {
+	if m.superColumn == nil {
+		return r
+	}
+	sr := &Row{Key: r.Key, Columns: []*Column{}}
+	for _, sc := range r.SuperColumns {
+		if bytes.Equal(sc.Name, m.superColumn) {
+			sr.Columns = sc.Columns
+		}
+	}
+	return sr
+}
+
 func (m *MockReader) sliceRow(r *Row) *Row {
+	r = m.superRow(r)
 	if m.slice != nil {
 		slice := m.slice
 		if slice.Reversed {
@@ -173,3 +282,155 @@ func (m *MockReader) sliceRow(r *Row) *Row {
 	}
 	return r
 }
+
+func (m *MockReader) RangeIter(r *Range) RowIterator {
+	return m.RangeIterContext(context.Background(), r)
+}
+
+func (m *MockReader) RangeIterContext(ctx context.Context, r *Range) RowIterator {
+	it := &mockRowIterator{ctx: ctx}
+	rows := m.pool.Rows(m.cf)
+	after := []byte(nil)
+	if r != nil && r.Cursor != "" {
+		var err error
+		if after, err = base64.RawURLEncoding.DecodeString(r.Cursor); err != nil {
+			it.err = ErrInvalidCursor
+			return it
+		}
+	}
+	started := after == nil && (r == nil || len(r.Start) == 0)
+	for _, row := range rows {
+		if !started {
+			if after != nil {
+				started = bytes.Equal(row.Key, after)
+				continue
+			}
+			started = bytes.Equal(row.Key, r.Start)
+		}
+		if started {
+			checkExpired(row)
+			it.rows = append(it.rows, m.sliceRow(row))
+		}
+		if r != nil && r.Cursor == "" && len(r.End) > 0 && bytes.Equal(row.Key, r.End) {
+			break
+		}
+	}
+	it.last = after
+	return it
+}
+
+// mockRowIterator returns the rows of the range, its cursor is the key of the last one returned
+type mockRowIterator struct {
+	ctx  context.Context
+	rows []*Row
+	last []byte
+	err  error
+}
+
+func (it *mockRowIterator) Next() (*Row, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if err := it.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(it.rows) == 0 {
+		return nil, Done
+	}
+	row := it.rows[0]
+	it.rows = it.rows[1:]
+	it.last = row.Key
+	return row, nil
+}
+
+func (it *mockRowIterator) Cursor() string {
+	return base64.RawURLEncoding.EncodeToString(it.last)
+}
+
+func (m *MockReader) ColumnIter(key []byte, slice *Slice) ColumnIterator {
+	return m.MultiColumnIterContext(context.Background(), [][]byte{key}, slice)
+}
+
+func (m *MockReader) ColumnIterContext(ctx context.Context, key []byte, slice *Slice) ColumnIterator {
+	return m.MultiColumnIterContext(ctx, [][]byte{key}, slice)
+}
+
+func (m *MockReader) MultiColumnIter(keys [][]byte, slice *Slice) ColumnIterator {
+	return m.MultiColumnIterContext(context.Background(), keys, slice)
+}
+
+func (m *MockReader) MultiColumnIterContext(ctx context.Context, keys [][]byte, slice *Slice) ColumnIterator {
+	it := &mockColumnIterator{ctx: ctx}
+	if slice == nil {
+		slice = &Slice{}
+	}
+	rows := m.pool.Rows(m.cf)
+	for _, key := range keys {
+		for _, r := range rows {
+			if !bytes.Equal(r.Key, key) {
+				continue
+			}
+			checkExpired(r)
+			r = m.superRow(r)
+			if len(r.SuperColumns) > 0 {
+				it.err = ErrSuperColumnIter
+				return it
+			}
+			columns := r.Columns
+			if slice.Reversed {
+				columns = make([]*Column, len(r.Columns))
+				for i, c := range r.Columns {
+					columns[len(columns)-1-i] = c
+				}
+			}
+			for _, c := range columns {
+				if inSlice(c.Name, slice.Start, slice.End, slice.Reversed) {
+					it.keys = append(it.keys, r.Key)
+					it.columns = append(it.columns, c)
+				}
+			}
+		}
+	}
+	return it
+}
+
+// inSlice returns true if the column name is between start and end, which are swapped if
+// reversed is set
+func inSlice(name, start, end []byte, reversed bool) bool {
+	if reversed {
+		start, end = end, start
+	}
+	if len(start) > 0 && bytes.Compare(name, start) < 0 {
+		return false
+	}
+	return len(end) == 0 || bytes.Compare(name, end) <= 0
+}
+
+// mockColumnIterator returns the columns of the rows, keys holds the row key of every column
+type mockColumnIterator struct {
+	ctx     context.Context
+	keys    [][]byte
+	columns []*Column
+	key     []byte
+	err     error
+}
+
+func (it *mockColumnIterator) Next() (*Column, error) {
+	if it.err != nil {
+		return nil, it.err
+	}
+	if err := it.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(it.columns) == 0 {
+		return nil, Done
+	}
+	c := it.columns[0]
+	it.key = it.keys[0]
+	it.columns, it.keys = it.columns[1:], it.keys[1:]
+	return c, nil
+}
+
+func (it *mockColumnIterator) Key() []byte {
+	return it.key
+}
@@ -2,6 +2,7 @@ package mockgossie
 
 import (
 	"bytes"
+	"context"
 	"sort"
 	"time"
 
@@ -30,6 +31,10 @@ func (w *MockWriter) ConsistencyLevel(c ConsistencyLevel) Writer {
 	return w
 }
 
+func (w *MockWriter) Trace(*UUID) Writer {
+	return w
+}
+
 func (w *MockWriter) Insert(cf string, row *Row) Writer {
 	return w.InsertTtl(cf, row, -1)
 }
@@ -38,17 +43,9 @@ func (w *MockWriter) InsertTtl(cf string, row *Row, ttl int) Writer {
 	rows := w.pool.Rows(cf)
 
 	t := thrift.Int64Ptr(now())
-	for _, c := range row.Columns {
-		if c.Timestamp == nil {
-			c.Timestamp = t
-		}
-		if ttl > 0 {
-			c.Ttl = thrift.Int32Ptr(int32(ttl))
-		}
-		if c.Ttl != nil {
-			// reset to the actual time to expire
-			c.Ttl = thrift.Int32Ptr(int32(now()/1e6) + *c.Ttl)
-		}
+	setTimes(row.Columns, t, ttl)
+	for _, sc := range row.SuperColumns {
+		setTimes(sc.Columns, t, ttl)
 	}
 
 	i := sort.Search(len(rows), func(i int) bool { return bytes.Compare(rows[i].Key, row.Key) >= 0 })
@@ -56,32 +53,31 @@ func (w *MockWriter) InsertTtl(cf string, row *Row, ttl int) Writer {
 		// Row already exists, merge the columns
 		e := rows[i]
 		checkExpired(e)
-		cols := e.Columns
-		for _, c := range row.Columns {
-			j := sort.Search(len(cols), func(j int) bool { return bytes.Compare(cols[j].Name, c.Name) >= 0 })
-			if j < len(cols) && bytes.Equal(cols[j].Name, c.Name) {
-				// Column already exists, pick the one with the greater timestamp
-				ec := cols[j]
-				et := *t
-				if ec != nil {
-					et = *ec.Timestamp
-				}
-				if *c.Timestamp >= et {
-					ec.Value = c.Value
-					ec.Ttl = c.Ttl
-					ec.Timestamp = c.Timestamp
-				}
+		e.Columns = mergeColumns(e.Columns, row.Columns, t)
+		scs := e.SuperColumns
+		for _, sc := range row.SuperColumns {
+			j := sort.Search(len(scs), func(j int) bool { return bytes.Compare(scs[j].Name, sc.Name) >= 0 })
+			if j < len(scs) && bytes.Equal(scs[j].Name, sc.Name) {
+				// Super column already exists, merge the subcolumns
+				scs[j].Columns = mergeColumns(scs[j].Columns, sc.Columns, t)
 			} else {
-				// New column, insert sorted
-				cols = append(cols, c)
-				copy(cols[j+1:], cols[j:])
-				cols[j] = c
+				// New super column, insert sorted
+				sort.Sort(Columns(sc.Columns))
+				scs = append(scs, sc)
+				copy(scs[j+1:], scs[j:])
+				scs[j] = sc
 			}
 		}
-		e.Columns = cols
+		e.SuperColumns = scs
 	} else {
 		// New row, insert sorted
 		sort.Sort(Columns(row.Columns))
+		sort.Slice(row.SuperColumns, func(i, j int) bool {
+			return bytes.Compare(row.SuperColumns[i].Name, row.SuperColumns[j].Name) < 0
+		})
+		for _, sc := range row.SuperColumns {
+			sort.Sort(Columns(sc.Columns))
+		}
 		rows = append(rows, row)
 		copy(rows[i+1:], rows[i:])
 		rows[i] = row
@@ -92,17 +88,73 @@ func (w *MockWriter) InsertTtl(cf string, row *Row, ttl int) Writer {
 	return w
 }
 
+// setTimes sets the timestamp of the columns that have none and their time to expire
+func setTimes(columns []*Column, t *int64, ttl int) {
+	for _, c := range columns {
+		if c.Timestamp == nil {
+			c.Timestamp = t
+		}
+		if ttl > 0 {
+			c.Ttl = thrift.Int32Ptr(int32(ttl))
+		}
+		if c.Ttl != nil {
+			// reset to the actual time to expire
+			c.Ttl = thrift.Int32Ptr(int32(now()/1e6) + *c.Ttl)
+		}
+	}
+}
+
+// mergeColumns inserts the columns into the sorted cols, keeping the one with the greater
+// timestamp when both have the same name
+func mergeColumns(cols, columns []*Column, t *int64) []*Column {
+	for _, c := range columns {
+		j := sort.Search(len(cols), func(j int) bool { return bytes.Compare(cols[j].Name, c.Name) >= 0 })
+		if j < len(cols) && bytes.Equal(cols[j].Name, c.Name) {
+			// Column already exists, pick the one with the greater timestamp
+			ec := cols[j]
+			et := *t
+			if ec != nil {
+				et = *ec.Timestamp
+			}
+			if *c.Timestamp >= et {
+				ec.Value = c.Value
+				ec.Ttl = c.Ttl
+				ec.Timestamp = c.Timestamp
+			}
+		} else {
+			// New column, insert sorted
+			cols = append(cols, c)
+			copy(cols[j+1:], cols[j:])
+			cols[j] = c
+		}
+	}
+	return cols
+}
+
 func checkExpired(r *Row) {
-	for i := 0; i < len(r.Columns); {
-		c := r.Columns[i]
+	r.Columns = removeExpired(r.Columns)
+	scs := r.SuperColumns[:0]
+	for _, sc := range r.SuperColumns {
+		sc.Columns = removeExpired(sc.Columns)
+		if len(sc.Columns) > 0 {
+			scs = append(scs, sc)
+		}
+	}
+	r.SuperColumns = scs
+}
+
+func removeExpired(columns []*Column) []*Column {
+	for i := 0; i < len(columns); {
+		c := columns[i]
 		if isExpired(c) {
-			copy(r.Columns[i:], r.Columns[i+1:])
-			r.Columns[len(r.Columns)-1] = nil
-			r.Columns = r.Columns[:len(r.Columns)-1]
+			copy(columns[i:], columns[i+1:])
+			columns[len(columns)-1] = nil
+			columns = columns[:len(columns)-1]
 		} else {
 			i++
 		}
 	}
+	return columns
 }
 
 func isExpired(c *Column) bool {
@@ -167,6 +219,58 @@ func (w *MockWriter) DeleteColumns(cf string, key []byte, columns [][]byte) Writ
 	return w
 }
 
+func (w *MockWriter) DeleteSuperColumn(cf string, key []byte, superColumn []byte) Writer {
+	w.deleteSubColumns(cf, key, superColumn, func([]byte) bool { return true })
+	return w
+}
+
+func (w *MockWriter) DeleteSubColumns(cf string, key []byte, superColumn []byte, columns [][]byte) Writer {
+	w.deleteSubColumns(cf, key, superColumn, func(name []byte) bool {
+		for _, c := range columns {
+			if bytes.Equal(c, name) {
+				return true
+			}
+		}
+		return false
+	})
+	return w
+}
+
+// deleteSubColumns deletes the subcolumns of the super column matched by deleted, and the super
+// column when none is left
+func (w *MockWriter) deleteSubColumns(cf string, key []byte, superColumn []byte, deleted func(name []byte) bool) {
+	rows := w.pool.Rows(cf)
+
+	t := now()
+
+	i := sort.Search(len(rows), func(i int) bool { return bytes.Compare(rows[i].Key, key) >= 0 })
+	if i < len(rows) && bytes.Equal(rows[i].Key, key) {
+		// Row exists, delete the subcolumns
+		e := rows[i]
+		scs := e.SuperColumns
+		j := sort.Search(len(scs), func(j int) bool { return bytes.Compare(scs[j].Name, superColumn) >= 0 })
+		if j < len(scs) && bytes.Equal(scs[j].Name, superColumn) {
+			sc := scs[j]
+			cols := sc.Columns[:0]
+			for _, c := range sc.Columns {
+				if !deleted(c.Name) || t < *c.Timestamp {
+					cols = append(cols, c)
+				}
+			}
+			sc.Columns = cols
+			if len(cols) == 0 {
+				copy(scs[j:], scs[j+1:])
+				scs[len(scs)-1] = nil
+				e.SuperColumns = scs[:len(scs)-1]
+			}
+		}
+	}
+}
+
 func (w *MockWriter) Run() error {
 	return nil
 }
+
+func (w *MockWriter) RunContext(ctx context.Context) error {
+	return ctx.Err()
+}
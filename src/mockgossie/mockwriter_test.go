@@ -0,0 +1,63 @@
+package mockgossie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/wadey/gossie/src/cassandra"
+	. "github.com/wadey/gossie/src/gossie"
+)
+
+func testSuperColumn(name string, columns ...string) *SuperColumn {
+	sc := &SuperColumn{Name: []byte(name)}
+	for _, c := range columns {
+		sc.Columns = append(sc.Columns, &Column{Name: []byte(c), Value: []byte(c)})
+	}
+	return sc
+}
+
+func subColumnNames(t *testing.T, r Reader, key, superColumn string) []string {
+	row, err := r.SuperColumn([]byte(superColumn)).Get([]byte(key))
+	assert.NoError(t, err)
+	var names []string
+	for _, c := range row.Columns {
+		names = append(names, string(c.Name))
+	}
+	return names
+}
+
+func TestSuperColumns(t *testing.T) {
+	m := NewMockConnectionPool()
+	w := m.Writer()
+	w.Insert("cf", &Row{Key: []byte("k"), SuperColumns: []*SuperColumn{testSuperColumn("b", "y", "x"), testSuperColumn("a", "x")}})
+	w.Insert("cf", &Row{Key: []byte("k"), SuperColumns: []*SuperColumn{testSuperColumn("b", "z"), testSuperColumn("c", "x")}})
+
+	row, err := m.Reader().Cf("cf").Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(row.SuperColumns))
+	assert.Equal(t, []string{"x", "y", "z"}, subColumnNames(t, m.Reader().Cf("cf"), "k", "b"))
+
+	_, err = m.Reader().Cf("cf").ColumnIter([]byte("k"), nil).Next()
+	assert.Equal(t, ErrSuperColumnIter, err)
+	it := m.Reader().Cf("cf").SuperColumn([]byte("b")).ColumnIter([]byte("k"), &Slice{Start: []byte("y")})
+	c, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "y", string(c.Name))
+
+	w.DeleteSubColumns("cf", []byte("k"), []byte("b"), [][]byte{[]byte("x"), []byte("z")})
+	assert.Equal(t, []string{"y"}, subColumnNames(t, m.Reader().Cf("cf"), "k", "b"))
+	w.DeleteSubColumns("cf", []byte("k"), []byte("b"), [][]byte{[]byte("y")})
+	w.DeleteSuperColumn("cf", []byte("k"), []byte("a"))
+	row, err = m.Reader().Cf("cf").Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, []*SuperColumn{testSuperColumn("c", "x")}, stripTimes(row.SuperColumns))
+}
+
+func stripTimes(scs []*SuperColumn) []*SuperColumn {
+	for _, sc := range scs {
+		for _, c := range sc.Columns {
+			c.Timestamp = nil
+		}
+	}
+	return scs
+}